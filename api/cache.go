@@ -0,0 +1,223 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+)
+
+// Cache statuses reported in the X-Cache header
+const (
+	cacheHit   = "HIT"
+	cacheStale = "STALE"
+	cacheMiss  = "MISS"
+)
+
+// albumCache caches full album responses per key. Fresh entries are served
+// for ttl; for another stale period they are still served while a single
+// background request refreshes them. Concurrent misses of the same key
+// share one upstream request, and the least recently used entries are
+// evicted once the cache grows beyond maxBytes.
+type albumCache struct {
+	ttl      time.Duration
+	stale    time.Duration
+	maxBytes int64
+	fetch    func(ctx context.Context, key string) (*icloudalbum.Response, error)
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+	calls   map[string]*cacheCall
+}
+
+type cacheEntry struct {
+	key       string
+	resp      *icloudalbum.Response
+	size      int64
+	fetchedAt time.Time
+}
+
+// cacheCall is an upstream request shared by all callers waiting for it
+type cacheCall struct {
+	done  chan struct{}
+	entry *cacheEntry
+	err   error
+}
+
+// cachedAlbum is an album served from the cache
+type cachedAlbum struct {
+	Response  *icloudalbum.Response
+	FetchedAt time.Time
+	Status    string
+}
+
+// albumResponses is created in main from the configuration
+var albumResponses *albumCache
+
+// fetchAlbum fetches an album with URLs from iCloud, bounded by the
+// upstream timeout
+func fetchAlbum(ctx context.Context, key string) (*icloudalbum.Response, error) {
+	if timeout := config().Timeouts.Upstream; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return albumClient.GetImagesContext(ctx, key)
+}
+
+func newAlbumCache(ttl, stale time.Duration, maxBytes int64, fetch func(context.Context, string) (*icloudalbum.Response, error)) *albumCache {
+	return &albumCache{
+		ttl:      ttl,
+		stale:    stale,
+		maxBytes: maxBytes,
+		fetch:    fetch,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		calls:    make(map[string]*cacheCall),
+	}
+}
+
+// configure changes the freshness and size limits, evicting entries
+// beyond the new size limit
+func (c *albumCache) configure(ttl, stale time.Duration, maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl, c.stale, c.maxBytes = ttl, stale, maxBytes
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// freshness returns the time entries are fresh and then stale for
+func (c *albumCache) freshness() (time.Duration, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ttl, c.stale
+}
+
+// get returns the album key from the cache or iCloud. The returned
+// response has its own Photos slice, so callers may modify photos in place
+// but must not modify their derivatives.
+func (c *albumCache) get(ctx context.Context, key string) (*cachedAlbum, error) {
+	c.mu.Lock()
+	// With caching turned off, concurrent requests still share one fetch
+	if elem, ok := c.entries[key]; ok && c.ttl > 0 {
+		entry := elem.Value.(*cacheEntry)
+		age := time.Since(entry.fetchedAt)
+		switch {
+		case age < c.ttl:
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return entry.album(cacheHit), nil
+		case age < c.ttl+c.stale:
+			c.lru.MoveToFront(elem)
+			if _, refreshing := c.calls[key]; !refreshing {
+				c.start(context.WithoutCancel(ctx), key)
+			}
+			c.mu.Unlock()
+			return entry.album(cacheStale), nil
+		default:
+			c.remove(elem)
+		}
+	}
+
+	call, ok := c.calls[key]
+	if !ok {
+		// The request outlives callers that give up waiting for it
+		call = c.start(context.WithoutCancel(ctx), key)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	return call.entry.album(cacheMiss), nil
+}
+
+// start fetches key in the background and stores the result; the caller
+// must hold c.mu
+func (c *albumCache) start(ctx context.Context, key string) *cacheCall {
+	call := &cacheCall{done: make(chan struct{})}
+	c.calls[key] = call
+
+	go func() {
+		resp, err := c.fetch(ctx, key)
+
+		c.mu.Lock()
+		delete(c.calls, key)
+		if err != nil {
+			log.Printf("Error refreshing cached album key %s: %v", key, err)
+			call.err = err
+		} else {
+			call.entry = &cacheEntry{key: key, resp: resp, fetchedAt: time.Now()}
+			if c.ttl > 0 {
+				call.entry.size = responseSize(resp)
+				c.store(call.entry)
+			}
+		}
+		c.mu.Unlock()
+
+		close(call.done)
+	}()
+
+	return call
+}
+
+// store adds entry, replacing an older entry of the same key and evicting
+// the least recently used ones beyond maxBytes; the caller must hold c.mu
+func (c *albumCache) store(entry *cacheEntry) {
+	if elem, ok := c.entries[entry.key]; ok {
+		c.remove(elem)
+	}
+	if entry.size > c.maxBytes {
+		return
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size
+
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops elem from the cache; the caller must hold c.mu
+func (c *albumCache) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// album returns the entry with a copy of its photo slice
+func (e *cacheEntry) album(status string) *cachedAlbum {
+	photos := make([]icloudalbum.Image, len(e.resp.Photos))
+	copy(photos, e.resp.Photos)
+
+	return &cachedAlbum{
+		Response:  &icloudalbum.Response{Metadata: e.resp.Metadata, Photos: photos},
+		FetchedAt: e.fetchedAt,
+		Status:    status,
+	}
+}
+
+// responseSize estimates the memory used by resp by its JSON size
+func responseSize(resp *icloudalbum.Response) int64 {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+)
+
+func TestAlbumCacheGet(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		// wantStatus and wantFetches are for a get after the concurrent
+		// ones
+		wantStatus  string
+		wantFetches int32
+	}{
+		{"cached", time.Minute, cacheHit, 1},
+		{"caching off", 0, cacheMiss, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetches int32
+			release := make(chan struct{})
+			cache := newAlbumCache(tt.ttl, 0, 1<<20, func(ctx context.Context, key string) (*icloudalbum.Response, error) {
+				atomic.AddInt32(&fetches, 1)
+				<-release
+				return &icloudalbum.Response{Photos: []icloudalbum.Image{{PhotoGUID: key}}}, nil
+			})
+
+			albums := make([]*cachedAlbum, 5)
+			var wg sync.WaitGroup
+			for i := range albums {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					album, err := cache.get(context.Background(), "key")
+					if err != nil {
+						t.Errorf("get() error = %v", err)
+					}
+					albums[i] = album
+				}()
+			}
+			// Give all callers time to wait for the fetch before it returns
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if got := atomic.LoadInt32(&fetches); got != 1 {
+				t.Errorf("concurrent gets made %d requests, want 1", got)
+			}
+			for _, album := range albums {
+				if album == nil || album.Status != cacheMiss || album.Response.Photos[0].PhotoGUID != "key" {
+					t.Errorf("concurrent get() = %+v", album)
+				}
+			}
+
+			album, err := cache.get(context.Background(), "key")
+			if err != nil {
+				t.Fatalf("get() error = %v", err)
+			}
+			if album.Status != tt.wantStatus {
+				t.Errorf("get() status = %s, want %s", album.Status, tt.wantStatus)
+			}
+			if got := atomic.LoadInt32(&fetches); got != tt.wantFetches {
+				t.Errorf("got %d requests, want %d", got, tt.wantFetches)
+			}
+		})
+	}
+}
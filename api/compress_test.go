@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"br", "br"},
+		{"gzip, br", "br"},
+		{"br, gzip", "br"},
+		{"GZIP", "gzip"},
+		{"gzip, deflate, br;q=0.5", "gzip"},
+		{"gzip;q=0.8, br;q=0.9", "br"},
+		{" gzip ; q=1.0 , br;q=0.1", "gzip"},
+		{"br;q=0", ""},
+		{"br;q=0, gzip;q=0", ""},
+		{"br;q=0, gzip", "gzip"},
+		{"br;q=abc, gzip;q=0.1", "gzip"},
+		{"*", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got := negotiateEncoding(tt.accept); got != tt.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+)
+
+// setCacheHeaders sets ETag, Last-Modified and Cache-Control for a
+// response built from resp and answers conditional requests. It returns
+// true if a 304 Not Modified was sent and the body must be omitted.
+//
+// The ETag is weak, as the signed derivative URLs in the body change on
+// every fetch from iCloud even though the album is the same.
+func setCacheHeaders(w http.ResponseWriter, r *http.Request, resp *icloudalbum.Response) bool {
+	header := w.Header()
+	ttl, stale := albumResponses.freshness()
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d, stale-while-revalidate=%d",
+		int(ttl.Seconds()), int(stale.Seconds())))
+	header.Add("Vary", "Accept-Encoding")
+
+	etag := albumETag(resp, r)
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	lastModified := albumLastModified(resp)
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// albumETag derives an ETag from the stream ctag, which changes whenever
+// the album does, and the query parameters shaping the response
+func albumETag(resp *icloudalbum.Response, r *http.Request) string {
+	if resp.Metadata.StreamCtag == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(resp.Metadata.StreamCtag + "\x00" + r.URL.Path + "?" + r.URL.Query().Encode()))
+	return `W/"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// albumLastModified returns the time the most recent photo was added
+func albumLastModified(resp *icloudalbum.Response) time.Time {
+	var last time.Time
+	for _, photo := range resp.Photos {
+		if photo.BatchDateCreated.After(last) {
+			last = photo.BatchDateCreated
+		}
+	}
+	return last.Truncate(time.Second)
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since if the request
+// has no If-None-Match, as described in RFC 9110
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		return err == nil && !lastModified.After(since)
+	}
+	return false
+}
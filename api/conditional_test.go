@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	const etag = `W/"abc"`
+	modified := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		ifNoneMatch     string
+		ifModifiedSince string
+		etag            string
+		lastModified    time.Time
+		want            bool
+	}{
+		{name: "no conditions", etag: etag, lastModified: modified, want: false},
+		{name: "matching etag", ifNoneMatch: `W/"abc"`, etag: etag, want: true},
+		{name: "weak comparison", ifNoneMatch: `"abc"`, etag: etag, want: true},
+		{name: "etag in list", ifNoneMatch: `"x", W/"abc"`, etag: etag, want: true},
+		{name: "wildcard", ifNoneMatch: "*", etag: etag, want: true},
+		{name: "other etag", ifNoneMatch: `W/"x"`, etag: etag, want: false},
+		{name: "no etag", ifNoneMatch: "*", want: false},
+		{
+			name:            "if-none-match takes precedence",
+			ifNoneMatch:     `W/"x"`,
+			ifModifiedSince: "Sat, 01 Jun 2024 12:00:00 GMT",
+			etag:            etag,
+			lastModified:    modified,
+			want:            false,
+		},
+		{name: "not modified since", ifModifiedSince: "Sat, 01 Jun 2024 12:00:00 GMT", lastModified: modified, want: true},
+		{name: "modified later", ifModifiedSince: "Sat, 01 Jun 2024 11:59:59 GMT", lastModified: modified, want: false},
+		{name: "modified earlier", ifModifiedSince: "Sun, 02 Jun 2024 00:00:00 GMT", lastModified: modified, want: true},
+		{name: "invalid date", ifModifiedSince: "yesterday", lastModified: modified, want: false},
+		{name: "unknown modification time", ifModifiedSince: "Sat, 01 Jun 2024 12:00:00 GMT", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/album/token", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			if tt.ifModifiedSince != "" {
+				r.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			}
+			if got := notModified(r, tt.etag, tt.lastModified); got != tt.want {
+				t.Errorf("notModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
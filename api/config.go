@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the server, read from a YAML or TOML file
+// and overridden by environment variables
+type Config struct {
+	Listen       string         `yaml:"listen" toml:"listen"`
+	DataDir      string         `yaml:"data_dir" toml:"data_dir"`
+	PollInterval time.Duration  `yaml:"poll_interval" toml:"poll_interval"`
+	CORS         CORSConfig     `yaml:"cors" toml:"cors"`
+	Cache        CacheConfig    `yaml:"cache" toml:"cache"`
+	MediaCache   MediaConfig    `yaml:"media_cache" toml:"media_cache"`
+	Timeouts     TimeoutsConfig `yaml:"timeouts" toml:"timeouts"`
+	Logging      LoggingConfig  `yaml:"logging" toml:"logging"`
+	// Albums maps aliases, usable in place of album keys in URLs, to keys
+	Albums   map[string]string `yaml:"albums" toml:"albums"`
+	Features FeaturesConfig    `yaml:"features" toml:"features"`
+	Webhooks WebhooksConfig    `yaml:"webhooks" toml:"webhooks"`
+}
+
+// CORSConfig lists the origins allowed to call the API from browsers
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+}
+
+// CacheConfig configures the album response cache
+type CacheConfig struct {
+	TTL      time.Duration `yaml:"ttl" toml:"ttl"`
+	Stale    time.Duration `yaml:"stale" toml:"stale"`
+	MaxBytes int64         `yaml:"max_bytes" toml:"max_bytes"`
+}
+
+// MediaConfig configures the disk cache of proxied media
+type MediaConfig struct {
+	// Dir defaults to the media directory in DataDir
+	Dir      string `yaml:"dir" toml:"dir"`
+	MaxBytes int64  `yaml:"max_bytes" toml:"max_bytes"`
+}
+
+// TimeoutsConfig holds the timeouts of the HTTP server and of requests to
+// iCloud; zero disables a timeout
+type TimeoutsConfig struct {
+	ReadHeader time.Duration `yaml:"read_header" toml:"read_header"`
+	Read       time.Duration `yaml:"read" toml:"read"`
+	Write      time.Duration `yaml:"write" toml:"write"`
+	Idle       time.Duration `yaml:"idle" toml:"idle"`
+	Shutdown   time.Duration `yaml:"shutdown" toml:"shutdown"`
+	Upstream   time.Duration `yaml:"upstream" toml:"upstream"`
+}
+
+// LoggingConfig selects the log level, debug or info, and the format, text
+// or json
+type LoggingConfig struct {
+	Level  string `yaml:"level" toml:"level"`
+	Format string `yaml:"format" toml:"format"`
+}
+
+// FeaturesConfig turns optional endpoints on or off
+type FeaturesConfig struct {
+	Events   bool `yaml:"events" toml:"events"`
+	Frame    bool `yaml:"frame" toml:"frame"`
+	Proxy    bool `yaml:"proxy" toml:"proxy"`
+	Resize   bool `yaml:"resize" toml:"resize"`
+	Webhooks bool `yaml:"webhooks" toml:"webhooks"`
+}
+
+// WebhooksConfig configures the webhook endpoints
+type WebhooksConfig struct {
+	// AdminToken is the bearer token required by the webhook endpoints;
+	// webhooks cannot be turned on without it
+	AdminToken string `yaml:"admin_token" toml:"admin_token"`
+}
+
+// defaultConfig returns the configuration used without a config file
+func defaultConfig() *Config {
+	return &Config{
+		Listen:       ":8000",
+		DataDir:      "data",
+		PollInterval: time.Minute,
+		CORS: CORSConfig{
+			AllowedOrigins: []string{
+				"http://localhost:1313",
+				"https://travel.igl-web.de",
+				"https://traveldev.igl-web.de",
+			},
+		},
+		Cache: CacheConfig{
+			TTL:      5 * time.Minute,
+			Stale:    10 * time.Minute,
+			MaxBytes: 64 << 20,
+		},
+		MediaCache: MediaConfig{MaxBytes: 1 << 30},
+		Timeouts: TimeoutsConfig{
+			ReadHeader: 10 * time.Second,
+			Idle:       2 * time.Minute,
+			Shutdown:   10 * time.Second,
+		},
+		Logging: LoggingConfig{Level: "debug", Format: "text"},
+		Features: FeaturesConfig{
+			Events: true,
+			Frame:  true,
+			Proxy:  true,
+			Resize: true,
+			// Webhooks make the server send requests to registered URLs,
+			// so they are only turned on together with an admin token
+			Webhooks: false,
+		},
+	}
+}
+
+// currentConfig holds the active configuration, replaced on reload
+var currentConfig atomic.Pointer[Config]
+
+func init() {
+	currentConfig.Store(defaultConfig())
+}
+
+// config returns the active configuration, which must not be modified
+func config() *Config {
+	return currentConfig.Load()
+}
+
+// enabled reports whether the feature name is turned on
+func (f FeaturesConfig) enabled(name string) bool {
+	switch name {
+	case "events":
+		return f.Events
+	case "frame":
+		return f.Frame
+	case "proxy":
+		return f.Proxy
+	case "resize":
+		return f.Resize
+	case "webhooks":
+		return f.Webhooks
+	}
+	return false
+}
+
+// loadConfig reads the config file at path, if any, applies environment
+// overrides and validates the result. All problems found are reported in
+// a single error.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		if err := decodeConfigFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	var problems []string
+	problems = append(problems, cfg.applyEnv()...)
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		source := "configuration"
+		if path != "" {
+			source = path
+		}
+		return nil, fmt.Errorf("invalid %s:\n  %s", source, strings.Join(problems, "\n  "))
+	}
+
+	if cfg.MediaCache.Dir == "" {
+		cfg.MediaCache.Dir = filepath.Join(cfg.DataDir, "media")
+	}
+	return cfg, nil
+}
+
+// decodeConfigFile decodes a YAML or TOML file, told apart by extension,
+// into cfg. Unknown keys are errors, as they are most likely typos.
+func decodeConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			return fmt.Errorf("%s: unknown keys %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("%s: config file must be .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// applyEnv overrides settings from the environment and returns the
+// variables that could not be parsed
+func (c *Config) applyEnv() []string {
+	var problems []string
+	envDuration := func(name string, target *time.Duration) {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid duration %q", name, value))
+				return
+			}
+			*target = d
+		}
+	}
+	envInt64 := func(name string, target *int64) {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid number %q", name, value))
+				return
+			}
+			*target = n
+		}
+	}
+	envString := func(name string, target *string) {
+		if value := os.Getenv(name); value != "" {
+			*target = value
+		}
+	}
+
+	if port := os.Getenv("PORT"); port != "" {
+		c.Listen = ":" + port
+	}
+	envString("LISTEN_ADDR", &c.Listen)
+	envString("DATA_DIR", &c.DataDir)
+	envDuration("POLL_INTERVAL", &c.PollInterval)
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		c.CORS.AllowedOrigins = nil
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.CORS.AllowedOrigins = append(c.CORS.AllowedOrigins, origin)
+			}
+		}
+	}
+	envDuration("CACHE_TTL", &c.Cache.TTL)
+	envDuration("CACHE_STALE", &c.Cache.Stale)
+	envInt64("CACHE_MAX_BYTES", &c.Cache.MaxBytes)
+	envString("MEDIA_CACHE_DIR", &c.MediaCache.Dir)
+	envInt64("MEDIA_CACHE_MAX_BYTES", &c.MediaCache.MaxBytes)
+	envString("LOG_LEVEL", &c.Logging.Level)
+	envString("LOG_FORMAT", &c.Logging.Format)
+	envString("WEBHOOK_ADMIN_TOKEN", &c.Webhooks.AdminToken)
+	return problems
+}
+
+// validAlias restricts aliases to characters that need no escaping in URLs
+var validAlias = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// validate returns a description of every invalid setting
+func (c *Config) validate() []string {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if _, port, err := net.SplitHostPort(c.Listen); err != nil {
+		problem("listen: %q is not a host:port address", c.Listen)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		problem("listen: invalid port %q", port)
+	}
+	if c.DataDir == "" {
+		problem("data_dir: must not be empty")
+	}
+	if c.PollInterval < time.Second {
+		problem("poll_interval: must be at least 1s, got %s", c.PollInterval)
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			problem("cors.allowed_origins: %q is not an origin like https://example.com", origin)
+		}
+	}
+
+	durations := map[string]time.Duration{
+		"cache.ttl":            c.Cache.TTL,
+		"cache.stale":          c.Cache.Stale,
+		"timeouts.read_header": c.Timeouts.ReadHeader,
+		"timeouts.read":        c.Timeouts.Read,
+		"timeouts.write":       c.Timeouts.Write,
+		"timeouts.idle":        c.Timeouts.Idle,
+		"timeouts.shutdown":    c.Timeouts.Shutdown,
+		"timeouts.upstream":    c.Timeouts.Upstream,
+	}
+	for _, name := range sortedKeys(durations) {
+		if durations[name] < 0 {
+			problem("%s: must not be negative, got %s", name, durations[name])
+		}
+	}
+	if c.Cache.MaxBytes < 0 {
+		problem("cache.max_bytes: must not be negative")
+	}
+	if c.MediaCache.MaxBytes < 0 {
+		problem("media_cache.max_bytes: must not be negative")
+	}
+
+	if c.Features.Webhooks && c.Webhooks.AdminToken == "" {
+		problem("features.webhooks: requires webhooks.admin_token or WEBHOOK_ADMIN_TOKEN")
+	}
+
+	switch c.Logging.Level {
+	case "debug", "info":
+	default:
+		problem("logging.level: must be debug or info, got %q", c.Logging.Level)
+	}
+	switch c.Logging.Format {
+	case "text", "json":
+	default:
+		problem("logging.format: must be text or json, got %q", c.Logging.Format)
+	}
+
+	for _, alias := range sortedKeys(c.Albums) {
+		if !validAlias.MatchString(alias) {
+			problem("albums: alias %q may only contain letters, digits, - and _", alias)
+		}
+		if c.Albums[alias] == "" {
+			problem("albums: alias %q has no album key", alias)
+		}
+	}
+	return problems
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// restartSettings returns the names of settings that differ between c and
+// next but only take effect on restart
+func (c *Config) restartSettings(next *Config) []string {
+	var changed []string
+	if c.Listen != next.Listen {
+		changed = append(changed, "listen")
+	}
+	if c.DataDir != next.DataDir {
+		changed = append(changed, "data_dir")
+	}
+	if c.PollInterval != next.PollInterval {
+		changed = append(changed, "poll_interval")
+	}
+	if c.MediaCache != next.MediaCache {
+		changed = append(changed, "media_cache")
+	}
+	if c.Timeouts.ReadHeader != next.Timeouts.ReadHeader || c.Timeouts.Read != next.Timeouts.Read ||
+		c.Timeouts.Write != next.Timeouts.Write || c.Timeouts.Idle != next.Timeouts.Idle {
+		changed = append(changed, "timeouts")
+	}
+	return changed
+}
+
+// applyConfig makes cfg the active configuration and updates the parts of
+// the server that depend on it
+func applyConfig(cfg *Config) {
+	currentConfig.Store(cfg)
+	setupLogging(cfg.Logging)
+	if albumResponses != nil {
+		albumResponses.configure(cfg.Cache.TTL, cfg.Cache.Stale, cfg.Cache.MaxBytes)
+	}
+	corsHandler.update(cfg.CORS)
+}
+
+// reloadOnSIGHUP reloads the config file whenever the process receives
+// SIGHUP. An invalid file is reported and the current configuration kept.
+func reloadOnSIGHUP(path string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			next, err := loadConfig(path)
+			if err != nil {
+				log.Printf("Error reloading configuration, keeping the current one: %v", err)
+				continue
+			}
+
+			// Settings the server was started with stay in effect
+			current := config()
+			if changed := current.restartSettings(next); len(changed) > 0 {
+				log.Printf("Restart the server to apply changes to %s", strings.Join(changed, ", "))
+				next.Listen = current.Listen
+				next.DataDir = current.DataDir
+				next.PollInterval = current.PollInterval
+				next.MediaCache = current.MediaCache
+				next.Timeouts.ReadHeader = current.Timeouts.ReadHeader
+				next.Timeouts.Read = current.Timeouts.Read
+				next.Timeouts.Write = current.Timeouts.Write
+				next.Timeouts.Idle = current.Timeouts.Idle
+			}
+
+			applyConfig(next)
+			log.Printf("Reloaded configuration from %s", path)
+		}
+	}()
+}
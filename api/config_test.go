@@ -0,0 +1,265 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configEnv lists the variables read by applyEnv
+var configEnv = []string{
+	"PORT", "LISTEN_ADDR", "DATA_DIR", "POLL_INTERVAL", "CORS_ORIGINS",
+	"CACHE_TTL", "CACHE_STALE", "CACHE_MAX_BYTES", "MEDIA_CACHE_DIR",
+	"MEDIA_CACHE_MAX_BYTES", "LOG_LEVEL", "LOG_FORMAT", "WEBHOOK_ADMIN_TOKEN",
+}
+
+// clearConfigEnv hides the variables read by applyEnv from the test, as
+// applyEnv ignores empty values
+func clearConfigEnv(t *testing.T) {
+	for _, name := range configEnv {
+		t.Setenv(name, "")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		// want are substrings of the expected problems, in order
+		want []string
+	}{
+		{name: "defaults", modify: func(c *Config) {}},
+		{name: "listen on all interfaces", modify: func(c *Config) { c.Listen = ":80" }},
+		{name: "listen without port", modify: func(c *Config) { c.Listen = "localhost" }, want: []string{"listen: "}},
+		{name: "invalid port", modify: func(c *Config) { c.Listen = ":70000" }, want: []string{"listen: invalid port"}},
+		{name: "empty data dir", modify: func(c *Config) { c.DataDir = "" }, want: []string{"data_dir"}},
+		{name: "short poll interval", modify: func(c *Config) { c.PollInterval = time.Millisecond }, want: []string{"poll_interval"}},
+		{
+			name: "origins",
+			modify: func(c *Config) {
+				c.CORS.AllowedOrigins = []string{"*", "https://example.com/", "example.com", "https://example.com/path"}
+			},
+			want: []string{`"example.com"`, `"https://example.com/path"`},
+		},
+		{
+			name:   "negative durations",
+			modify: func(c *Config) { c.Cache.TTL = -time.Second; c.Timeouts.Write = -time.Second },
+			want:   []string{"cache.ttl", "timeouts.write"},
+		},
+		{
+			name:   "negative sizes",
+			modify: func(c *Config) { c.Cache.MaxBytes = -1; c.MediaCache.MaxBytes = -1 },
+			want:   []string{"cache.max_bytes", "media_cache.max_bytes"},
+		},
+		{name: "webhooks without token", modify: func(c *Config) { c.Features.Webhooks = true }, want: []string{"features.webhooks"}},
+		{
+			name:   "webhooks with token",
+			modify: func(c *Config) { c.Features.Webhooks = true; c.Webhooks.AdminToken = "secret" },
+		},
+		{
+			name:   "logging",
+			modify: func(c *Config) { c.Logging = LoggingConfig{Level: "warn", Format: "xml"} },
+			want:   []string{"logging.level", "logging.format"},
+		},
+		{
+			name:   "aliases",
+			modify: func(c *Config) { c.Albums = map[string]string{"ok": "key", "bad alias": "key", "empty": ""} },
+			want:   []string{`alias "bad alias" may only`, `alias "empty" has no album key`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tt.modify(cfg)
+
+			problems := cfg.validate()
+			if len(problems) != len(tt.want) {
+				t.Fatalf("validate() = %q, want %d problems", problems, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("problem %d = %q, want %q", i, problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigApplyEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(t *testing.T, c *Config)
+		want  []string
+	}{
+		{
+			name: "none",
+			check: func(t *testing.T, c *Config) {
+				if c.Listen != ":8000" || c.PollInterval != time.Minute {
+					t.Errorf("config = %+v, want defaults", c)
+				}
+			},
+		},
+		{
+			name: "port",
+			env:  map[string]string{"PORT": "9000"},
+			check: func(t *testing.T, c *Config) {
+				if c.Listen != ":9000" {
+					t.Errorf("Listen = %q, want :9000", c.Listen)
+				}
+			},
+		},
+		{
+			name: "listen address overrides port",
+			env:  map[string]string{"PORT": "9000", "LISTEN_ADDR": "127.0.0.1:8080"},
+			check: func(t *testing.T, c *Config) {
+				if c.Listen != "127.0.0.1:8080" {
+					t.Errorf("Listen = %q, want 127.0.0.1:8080", c.Listen)
+				}
+			},
+		},
+		{
+			name: "values",
+			env: map[string]string{
+				"DATA_DIR":              "/var/lib/album",
+				"POLL_INTERVAL":         "30s",
+				"CORS_ORIGINS":          " https://a.example , ,https://b.example",
+				"CACHE_TTL":             "1m",
+				"CACHE_MAX_BYTES":       "1024",
+				"MEDIA_CACHE_DIR":       "/tmp/media",
+				"MEDIA_CACHE_MAX_BYTES": "2048",
+				"LOG_LEVEL":             "info",
+				"LOG_FORMAT":            "json",
+				"WEBHOOK_ADMIN_TOKEN":   "secret",
+			},
+			check: func(t *testing.T, c *Config) {
+				if c.DataDir != "/var/lib/album" || c.PollInterval != 30*time.Second || c.Cache.TTL != time.Minute ||
+					c.Cache.MaxBytes != 1024 || c.MediaCache.Dir != "/tmp/media" || c.MediaCache.MaxBytes != 2048 ||
+					c.Logging != (LoggingConfig{Level: "info", Format: "json"}) || c.Webhooks.AdminToken != "secret" {
+					t.Errorf("config = %+v", c)
+				}
+				if strings.Join(c.CORS.AllowedOrigins, ",") != "https://a.example,https://b.example" {
+					t.Errorf("AllowedOrigins = %q", c.CORS.AllowedOrigins)
+				}
+			},
+		},
+		{
+			name: "invalid values",
+			env:  map[string]string{"POLL_INTERVAL": "soon", "CACHE_STALE": "10", "CACHE_MAX_BYTES": "1GB"},
+			check: func(t *testing.T, c *Config) {
+				if c.PollInterval != time.Minute || c.Cache.Stale != 10*time.Minute || c.Cache.MaxBytes != 64<<20 {
+					t.Errorf("config = %+v, want invalid values ignored", c)
+				}
+			},
+			want: []string{"POLL_INTERVAL", "CACHE_STALE", "CACHE_MAX_BYTES"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			cfg := defaultConfig()
+			problems := cfg.applyEnv()
+			if len(problems) != len(tt.want) {
+				t.Fatalf("applyEnv() = %q, want %d problems", problems, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(problems[i], want+":") {
+					t.Errorf("problem %d = %q, want %s", i, problems[i], want)
+				}
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		wantErr string
+		check   func(t *testing.T, c *Config)
+	}{
+		{
+			name:    "yaml",
+			file:    "config.yaml",
+			content: "listen: \":9000\"\npoll_interval: 2m\nalbums:\n  trip: B0abc\n",
+			check: func(t *testing.T, c *Config) {
+				if c.Listen != ":9000" || c.PollInterval != 2*time.Minute || c.Albums["trip"] != "B0abc" {
+					t.Errorf("config = %+v", c)
+				}
+				if c.MediaCache.Dir != filepath.Join("data", "media") {
+					t.Errorf("MediaCache.Dir = %q, want data/media", c.MediaCache.Dir)
+				}
+			},
+		},
+		{
+			name:    "toml",
+			file:    "config.toml",
+			content: "data_dir = \"/srv\"\n[logging]\nlevel = \"info\"\n",
+			check: func(t *testing.T, c *Config) {
+				if c.DataDir != "/srv" || c.Logging.Level != "info" || c.MediaCache.Dir != filepath.Join("/srv", "media") {
+					t.Errorf("config = %+v", c)
+				}
+			},
+		},
+		{
+			name:    "environment overrides file",
+			file:    "config.yaml",
+			content: "listen: \":9000\"\n",
+			env:     map[string]string{"PORT": "9100"},
+			check: func(t *testing.T, c *Config) {
+				if c.Listen != ":9100" {
+					t.Errorf("Listen = %q, want :9100", c.Listen)
+				}
+			},
+		},
+		{name: "empty yaml", file: "config.yaml", content: ""},
+		{name: "unknown yaml key", file: "config.yaml", content: "lisen: \":9000\"\n", wantErr: "lisen"},
+		{name: "unknown toml key", file: "config.toml", content: "lisen = \":9000\"\n", wantErr: "unknown keys lisen"},
+		{name: "unsupported extension", file: "config.json", content: "{}", wantErr: ".yaml, .yml or .toml"},
+		{
+			name:    "all problems are reported",
+			file:    "config.yaml",
+			content: "data_dir: \"\"\nlogging:\n  level: warn\n",
+			env:     map[string]string{"CACHE_TTL": "soon"},
+			wantErr: "CACHE_TTL: invalid duration \"soon\"\n  data_dir: must not be empty\n  logging.level",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := loadConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			if tt.check != nil {
+				tt.check(t, cfg)
+			}
+		})
+	}
+}
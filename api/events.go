@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sseHeartbeat is the interval comments are sent at to keep idle
+// connections open through proxies
+const sseHeartbeat = 30 * time.Second
+
+// getAlbumEventsHandler streams the changes of an album as server-sent
+// events. All clients watching the same album share one background poll.
+func getAlbumEventsHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := startRequestSpan(r, "GET /album/{key}/events")
+	defer span.End()
+
+	key := mux.Vars(r)["key"]
+	if key == "" {
+		sendError(w, http.StatusBadRequest, "Missing album key", "Album key is required")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendError(w, http.StatusInternalServerError, "Streaming unsupported", "The server cannot stream responses")
+		return
+	}
+
+	events, unsubscribe := poller.subscribe(key)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	// The stream outlives the configured write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+
+	// Ask clients to wait a poll interval before reconnecting
+	fmt.Fprintf(w, "retry: %d\n\n", poller.interval.Milliseconds())
+	flusher.Flush()
+
+	log.Printf("Client subscribed to events of album key: %s", key)
+	defer log.Printf("Client unsubscribed from events of album key: %s", key)
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			payload := newEventPayload(key, event)
+			data, err := json.Marshal(payload)
+			if err != nil {
+				log.Printf("Error encoding event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", payload.ID, payload.Event, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	// frameMaxStates is the number of device states kept; the least
+	// recently used are dropped beyond it
+	frameMaxStates = 1000
+	// frameStateTTL is how long the state of an unused device is kept
+	frameStateTTL = 90 * 24 * time.Hour
+	// frameSaveDelay batches the state changes written to disk
+	frameSaveDelay = 10 * time.Second
+)
+
+// validDevice restricts device IDs to short names that are safe to log
+var validDevice = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// FrameResponse represents the photo a frame should show next
+type FrameResponse struct {
+	GUID      string `json:"guid"`
+	Caption   string `json:"caption"`
+	URL       string `json:"url"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	AssetType string `json:"assetType"`
+	Position  int    `json:"position"`
+	Total     int    `json:"total"`
+}
+
+// frameState is the persisted shuffle order and position of one device
+// for one album
+type frameState struct {
+	Order    []string  `json:"order"`
+	Position int       `json:"position"`
+	Cycle    int       `json:"cycle"`
+	Updated  time.Time `json:"updated"`
+}
+
+// frameStore persists the state of all frames to a JSON file
+type frameStore struct {
+	path string
+
+	mu     sync.Mutex
+	states map[string]*frameState
+	// saveTimer is set while a save is pending
+	saveTimer *time.Timer
+}
+
+// frames is created in main, in the configured data directory
+var frames *frameStore
+
+func newFrameStore(path string) *frameStore {
+	s := &frameStore{path: path, states: make(map[string]*frameState)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading frame state: %v", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s.states); err != nil {
+		log.Printf("Error parsing frame state %s: %v", path, err)
+	}
+	return s
+}
+
+// next advances the frame of device on album key and returns the GUID to
+// show, its position in the current cycle and the cycle length. guids are
+// the eligible items of the album in album order.
+func (s *frameStore) next(key, device string, guids []string) (string, int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := key + "/" + device
+	state, ok := s.states[id]
+	if !ok {
+		s.prune()
+		state = &frameState{}
+		s.states[id] = state
+	}
+
+	state.sync(id, guids)
+	if state.Position >= len(state.Order) {
+		state.Cycle++
+		state.Order = shuffled(id, state.Cycle, guids)
+		state.Position = 0
+	}
+
+	guid := state.Order[state.Position]
+	state.Position++
+	state.Updated = time.Now()
+
+	if s.saveTimer == nil {
+		s.saveTimer = time.AfterFunc(frameSaveDelay, s.flush)
+	}
+	return guid, state.Position, len(state.Order)
+}
+
+// prune drops states unused for frameStateTTL and the least recently used
+// ones beyond frameMaxStates-1, making room for a new one; the caller must
+// hold s.mu
+func (s *frameStore) prune() {
+	cutoff := time.Now().Add(-frameStateTTL)
+	for id, state := range s.states {
+		if state.Updated.Before(cutoff) {
+			delete(s.states, id)
+		}
+	}
+	if len(s.states) < frameMaxStates {
+		return
+	}
+
+	ids := make([]string, 0, len(s.states))
+	for id := range s.states {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return s.states[ids[i]].Updated.Before(s.states[ids[j]].Updated) })
+	for _, id := range ids[:len(ids)-frameMaxStates+1] {
+		delete(s.states, id)
+	}
+}
+
+// sync drops items removed from the album from the order and inserts new
+// ones at random positions among the items not shown yet in this cycle
+func (st *frameState) sync(id string, guids []string) {
+	current := make(map[string]bool, len(guids))
+	for _, guid := range guids {
+		current[guid] = true
+	}
+
+	known := make(map[string]bool, len(st.Order))
+	order := st.Order[:0]
+	position := st.Position
+	for i, guid := range st.Order {
+		if !current[guid] {
+			if i < st.Position {
+				position--
+			}
+			continue
+		}
+		known[guid] = true
+		order = append(order, guid)
+	}
+	st.Order = order
+	st.Position = position
+
+	rng := rand.New(rand.NewSource(seed(id, st.Cycle) + int64(len(order))))
+	for _, guid := range guids {
+		if known[guid] {
+			continue
+		}
+		i := st.Position + rng.Intn(len(st.Order)-st.Position+1)
+		st.Order = append(st.Order, "")
+		copy(st.Order[i+1:], st.Order[i:])
+		st.Order[i] = guid
+	}
+}
+
+// shuffled returns guids in a random order, stable for id and cycle
+func shuffled(id string, cycle int, guids []string) []string {
+	order := make([]string, len(guids))
+	copy(order, guids)
+	rng := rand.New(rand.NewSource(seed(id, cycle)))
+	rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	return order
+}
+
+func seed(id string, cycle int) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%d", id, cycle)
+	return int64(h.Sum64())
+}
+
+// flush writes pending state changes to disk
+func (s *frameStore) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saveTimer == nil {
+		return
+	}
+	s.saveTimer.Stop()
+	s.saveTimer = nil
+	if err := writeJSONFile(s.path, s.states, 0o600); err != nil {
+		log.Printf("Error saving frame state: %v", err)
+	}
+}
+
+func getFrameNextHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startRequestSpan(r, "GET /album/{key}/frame/next")
+	defer span.End()
+
+	key := mux.Vars(r)["key"]
+	if key == "" {
+		sendError(w, http.StatusBadRequest, "Missing album key", "Album key is required")
+		return
+	}
+
+	query := r.URL.Query()
+	width, _ := strconv.Atoi(query.Get("width"))
+	height, _ := strconv.Atoi(query.Get("height"))
+	if width <= 0 || height <= 0 {
+		sendError(w, http.StatusBadRequest, "Invalid display size", "width and height must be positive integers")
+		return
+	}
+	withVideos, _ := strconv.ParseBool(query.Get("videos"))
+	redirect, _ := strconv.ParseBool(query.Get("redirect"))
+
+	device := query.Get("device")
+	if device == "" {
+		device = r.Header.Get("X-Device-ID")
+	}
+	if device == "" {
+		device = "default"
+	}
+	if !validDevice.MatchString(device) {
+		sendError(w, http.StatusBadRequest, "Invalid device", "device must be 1 to 64 letters, digits, '.', '_' or '-'")
+		return
+	}
+
+	log.Printf("DEBUG: Requesting next frame for device %s on album with key: %s", device, key)
+
+	cached, err := albumResponses.get(ctx, key)
+	if err != nil {
+		log.Printf("DEBUG: GetImages returned ERROR: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		sendError(w, http.StatusInternalServerError, "Failed to fetch album", err.Error())
+		return
+	}
+	w.Header().Set("X-Cache", cached.Status)
+	response := cached.Response
+
+	photos := make(map[string]icloudalbum.Image, len(response.Photos))
+	guids := make([]string, 0, len(response.Photos))
+	for _, photo := range response.Photos {
+		if photo.MediaAssetType != nil && *photo.MediaAssetType == "video" && !withVideos {
+			continue
+		}
+		photos[photo.PhotoGUID] = photo
+		guids = append(guids, photo.PhotoGUID)
+	}
+
+	if len(guids) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	guid, position, total := frames.next(key, device, guids)
+	photo := photos[guid]
+
+	_, derivative, ok := icloudalbum.BestFitDerivative(width, height)(photo)
+	if !ok || derivative.URL == nil {
+		sendError(w, http.StatusBadGateway, "No URL available", fmt.Sprintf("photo %s has no resolvable derivative", guid))
+		return
+	}
+
+	if redirect {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, *derivative.URL, http.StatusFound)
+		return
+	}
+
+	frameResponse := FrameResponse{
+		GUID:      guid,
+		Caption:   photo.Caption,
+		URL:       *derivative.URL,
+		Width:     derivative.Width,
+		Height:    derivative.Height,
+		AssetType: newImageResponse(photo).AssetType,
+		Position:  position,
+		Total:     total,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(frameResponse); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+		return
+	}
+
+	log.Printf("Successfully served frame %d/%d for device %s on album key: %s", position, total, device, key)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFrameStoreNext(t *testing.T) {
+	s := newFrameStore(filepath.Join(t.TempDir(), "frames.json"))
+	defer s.flush()
+
+	guids := []string{"a", "b", "c", "d"}
+	for cycle := 0; cycle < 3; cycle++ {
+		seen := make(map[string]bool)
+		for i := 1; i <= len(guids); i++ {
+			guid, position, total := s.next("key", "tv", guids)
+			if position != i || total != len(guids) {
+				t.Fatalf("cycle %d: position %d/%d, want %d/%d", cycle, position, total, i, len(guids))
+			}
+			if seen[guid] {
+				t.Fatalf("cycle %d: %s shown twice", cycle, guid)
+			}
+			seen[guid] = true
+		}
+	}
+
+	// Removed items are skipped, added ones shown in the current cycle
+	first, _, _ := s.next("key", "tv", guids)
+	remaining := []string{"e"}
+	for _, guid := range guids {
+		if guid != first && guid != "a" {
+			remaining = append(remaining, guid)
+		}
+	}
+	updated := append([]string{"e"}, guids[1:]...)
+	seen := make(map[string]bool)
+	for range remaining {
+		guid, _, _ := s.next("key", "tv", updated)
+		if guid == "a" {
+			t.Fatalf("removed item shown")
+		}
+		seen[guid] = true
+	}
+	for _, guid := range remaining {
+		if !seen[guid] {
+			t.Errorf("%s not shown in the cycle, got %v", guid, seen)
+		}
+	}
+}
+
+func TestFrameStorePrune(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		states  map[string]time.Time
+		want    []string
+		wantLen int
+	}{
+		{
+			name:    "expired",
+			states:  map[string]time.Time{"k/old": now.Add(-frameStateTTL - time.Hour), "k/new": now},
+			want:    []string{"k/new"},
+			wantLen: 1,
+		},
+		{
+			name: "over limit",
+			states: func() map[string]time.Time {
+				states := make(map[string]time.Time)
+				for i := 0; i < frameMaxStates; i++ {
+					states[fmt.Sprintf("k/%d", i)] = now.Add(time.Duration(i) * time.Second)
+				}
+				return states
+			}(),
+			want:    []string{"k/1", fmt.Sprintf("k/%d", frameMaxStates-1)},
+			wantLen: frameMaxStates - 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &frameStore{states: make(map[string]*frameState)}
+			for id, updated := range tt.states {
+				s.states[id] = &frameState{Updated: updated}
+			}
+
+			s.prune()
+
+			if len(s.states) != tt.wantLen {
+				t.Errorf("%d states left, want %d", len(s.states), tt.wantLen)
+			}
+			for _, id := range tt.want {
+				if _, ok := s.states[id]; !ok {
+					t.Errorf("state %s pruned", id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// debugPrefix marks debug messages, which are dropped at level info
+const debugPrefix = "DEBUG: "
+
+// timestampLen is the length of the timestamp log.LstdFlags puts before
+// every message, as in "2006/01/02 15:04:05 "
+const timestampLen = len("2006/01/02 15:04:05 ")
+
+// logWriter filters and formats the output of the standard logger, which
+// calls Write once per message. Levels are told by the start of the
+// message, as in "DEBUG: ..." and "Error ...".
+type logWriter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  string
+	format string
+	// skip is the length of the timestamp before the message
+	skip int
+}
+
+var logOutput = &logWriter{out: os.Stderr, level: "debug", format: "text"}
+
+// setupLogging routes the standard logger through logOutput configured by
+// cfg
+func setupLogging(cfg LoggingConfig) {
+	logOutput.mu.Lock()
+	logOutput.level = cfg.Level
+	logOutput.format = cfg.Format
+	if cfg.Format == "json" {
+		// JSON entries carry their own timestamp
+		logOutput.skip = 0
+		log.SetFlags(0)
+	} else {
+		logOutput.skip = timestampLen
+		log.SetFlags(log.LstdFlags)
+	}
+	logOutput.mu.Unlock()
+
+	log.SetOutput(logOutput)
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	message := string(p)
+	if len(message) >= w.skip {
+		message = message[w.skip:]
+	}
+	level := "info"
+	if strings.HasPrefix(message, "Error ") {
+		level = "error"
+	}
+	if strings.HasPrefix(message, debugPrefix) {
+		if w.level != "debug" {
+			return len(p), nil
+		}
+		level = "debug"
+		message = strings.TrimPrefix(message, debugPrefix)
+	}
+
+	if w.format != "json" {
+		return w.out.Write(p)
+	}
+
+	entry, err := json.Marshal(struct {
+		Time    time.Time `json:"time"`
+		Level   string    `json:"level"`
+		Message string    `json:"message"`
+	}{time.Now(), level, strings.TrimSuffix(message, "\n")})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(append(entry, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestLogWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		level     string
+		format    string
+		input     string
+		wantLevel string
+		wantMsg   string
+		dropped   bool
+	}{
+		{"info", "debug", "json", "Successfully served album\n", "info", "Successfully served album", false},
+		{"error", "debug", "json", "Error encoding JSON response: EOF\n", "error", "Error encoding JSON response: EOF", false},
+		{"debug", "debug", "json", "DEBUG: Found 3 photos\n", "debug", "Found 3 photos", false},
+		{"debug dropped at info", "info", "json", "DEBUG: Found 3 photos\n", "", "", true},
+		{"error inside message", "debug", "json", "Served caption \"Error 404\"\n", "info", "Served caption \"Error 404\"", false},
+		{"debug inside message", "info", "json", "Caption is DEBUG: test\n", "info", "Caption is DEBUG: test", false},
+		{"text keeps timestamp", "debug", "text", "2024/06/01 12:00:00 Error reading file\n", "", "2024/06/01 12:00:00 Error reading file\n", false},
+		{"text debug dropped at info", "info", "text", "2024/06/01 12:00:00 DEBUG: Found 3 photos\n", "", "", true},
+		{"text debug inside message", "info", "text", "2024/06/01 12:00:00 Caption DEBUG: x\n", "", "2024/06/01 12:00:00 Caption DEBUG: x\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := &logWriter{out: &out, level: tt.level, format: tt.format}
+			if tt.format == "text" {
+				w.skip = timestampLen
+			}
+
+			if _, err := w.Write([]byte(tt.input)); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if tt.dropped {
+				if out.Len() != 0 {
+					t.Errorf("Write() output = %q, want none", out.String())
+				}
+				return
+			}
+			if tt.format == "text" {
+				if out.String() != tt.wantMsg {
+					t.Errorf("Write() output = %q, want %q", out.String(), tt.wantMsg)
+				}
+				return
+			}
+
+			var entry struct {
+				Level   string `json:"level"`
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatalf("invalid JSON %q: %v", out.String(), err)
+			}
+			if entry.Level != tt.wantLevel || entry.Message != tt.wantMsg {
+				t.Errorf("Write() = %s %q, want %s %q", entry.Level, entry.Message, tt.wantLevel, tt.wantMsg)
+			}
+		})
+	}
+}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sort"
 	"strconv"
+	"syscall"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
 	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+	"go.opentelemetry.io/otel/codes"
 )
 
 // ImageResponse represents the simplified photo response structure
@@ -20,6 +27,34 @@ type ImageResponse struct {
 	FullImageURL string `json:"fullImageUrl"`
 	ThumbnailURL string `json:"thumbnailUrl"`
 	AssetType    string `json:"assetType"`
+
+	// Responsive image data, only included with ?srcset=true
+	Derivatives []DerivativeResponse `json:"derivatives,omitempty"`
+	Srcset      string               `json:"srcset,omitempty"`
+	Sizes       string               `json:"sizes,omitempty"`
+}
+
+// DerivativeResponse represents a single derivative of a photo
+type DerivativeResponse struct {
+	Key      string `json:"key"`
+	URL      string `json:"url"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	FileSize int64  `json:"fileSize"`
+}
+
+// albumClient is shared by all requests
+var albumClient = icloudalbum.NewClient()
+
+// TimelineGroupResponse represents a group of the album timeline
+type TimelineGroupResponse struct {
+	Key    string        `json:"key"`
+	Start  *time.Time    `json:"start,omitempty"`
+	End    *time.Time    `json:"end,omitempty"`
+	Count  int           `json:"count"`
+	Photos int           `json:"photos"`
+	Videos int           `json:"videos"`
+	Cover  ImageResponse `json:"cover"`
 }
 
 // ErrorResponse represents error response structure
@@ -29,41 +64,97 @@ type ErrorResponse struct {
 }
 
 func main() {
-	// Get port from environment or default to 8000
-	portStr := os.Getenv("PORT")
-	if portStr == "" {
-		portStr = "8000"
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flag.Parse()
+
+	// Environment variables override the config file
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	applyConfig(cfg)
+	if *configPath != "" {
+		log.Printf("Loaded configuration from %s", *configPath)
 	}
-	port, err := strconv.Atoi(portStr)
+
+	albumResponses = newAlbumCache(cfg.Cache.TTL, cfg.Cache.Stale, cfg.Cache.MaxBytes, fetchAlbum)
+	mediaFiles = newMediaCache(cfg.MediaCache.Dir, cfg.MediaCache.MaxBytes)
+	poller = newAlbumPoller(cfg.PollInterval)
+	frames = newFrameStore(filepath.Join(cfg.DataDir, "frames.json"))
+	webhooks = newWebhookStore(filepath.Join(cfg.DataDir, "webhooks.json"))
+
+	// Setup OpenTelemetry tracing if an OTLP endpoint is configured
+	shutdownTracing, err := setupTracing(context.Background())
 	if err != nil {
-		log.Fatal("Invalid PORT environment variable:", err)
+		log.Fatal("Failed to setup tracing:", err)
 	}
+	defer shutdownTracing(context.Background())
 
 	// Create router
 	r := mux.NewRouter()
+	r.Use(resolveAlbumAlias)
 
 	// Add album endpoint
-	r.HandleFunc("/album/{key}", getAlbumHandler).Methods("GET")
-
-	// Setup CORS - matching the origins from the TypeScript version
-	c := cors.New(cors.Options{
-		AllowedOrigins: []string{
-			"http://localhost:1313",
-			"https://travel.igl-web.de", 
-			"https://traveldev.igl-web.de",
-		},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"*"},
-	})
+	r.HandleFunc("/album/{key}", compress(getAlbumHandler)).Methods("GET")
+	r.HandleFunc("/album/{key}/detailed", compress(getAlbumDetailedHandler)).Methods("GET")
+	r.HandleFunc("/album/{key}/stats", compress(getAlbumStatsHandler)).Methods("GET")
+	r.HandleFunc("/album/{key}/timeline", compress(getAlbumTimelineHandler)).Methods("GET")
+	r.HandleFunc("/album/{key}/geo.json", compress(getAlbumGeoJSONHandler)).Methods("GET")
+	r.HandleFunc("/album/{key}/frame/next", requireFeature("frame", getFrameNextHandler)).Methods("GET")
+	r.HandleFunc("/album/{key}/events", requireFeature("events", getAlbumEventsHandler)).Methods("GET")
+	r.HandleFunc("/album/{key}/photo/{guid}/{derivative}", requireFeature("proxy", getPhotoProxyHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/album/{key}/webhooks", requireFeature("webhooks", requireWebhookAuth(createWebhookHandler))).Methods("POST")
+	r.HandleFunc("/album/{key}/webhooks", requireFeature("webhooks", requireWebhookAuth(listWebhooksHandler))).Methods("GET")
+	r.HandleFunc("/album/{key}/webhooks/{id}", requireFeature("webhooks", requireWebhookAuth(deleteWebhookHandler))).Methods("DELETE")
+	r.HandleFunc("/album/{key}/webhooks/{id}/deliveries", requireFeature("webhooks", requireWebhookAuth(listWebhookDeliveriesHandler))).Methods("GET")
+	r.HandleFunc("/album/{key}/webhooks/{id}/deliveries/{delivery}/replay", requireFeature("webhooks", requireWebhookAuth(replayWebhookDeliveryHandler))).Methods("POST")
 
-	// Apply CORS middleware
-	handler := c.Handler(r)
+	// Resume watching albums with registered webhooks
+	webhooks.start()
 
-	fmt.Printf("Listening on: localhost:%d\n", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), handler))
+	// Apply CORS middleware, following the configured origins on reload
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           corsHandler.wrap(r),
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader,
+		ReadTimeout:       cfg.Timeouts.Read,
+		WriteTimeout:      cfg.Timeouts.Write,
+		IdleTimeout:       cfg.Timeouts.Idle,
+	}
+
+	// Finish running requests on SIGINT and SIGTERM
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		log.Printf("Shutting down")
+		ctx := context.Background()
+		if timeout := config().Timeouts.Shutdown; timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down: %v", err)
+		}
+	}()
+
+	// Reload the config file on SIGHUP
+	if *configPath != "" {
+		reloadOnSIGHUP(*configPath)
+	}
+
+	fmt.Printf("Listening on: %s\n", cfg.Listen)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Print(err)
+	}
+	frames.flush()
 }
 
 func getAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startRequestSpan(r, "GET /album/{key}")
+	defer span.End()
+
 	// Extract the key from URL parameters
 	vars := mux.Vars(r)
 	key := vars["key"]
@@ -74,18 +165,37 @@ func getAlbumHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// srcset=true adds every derivative and a srcset to each photo; sizes
+	// overrides the default sizes hint
+	responsive, _ := strconv.ParseBool(r.URL.Query().Get("srcset"))
+	sizes := r.URL.Query().Get("sizes")
+
+	// Resolve the export format before hitting iCloud
+	var exporter icloudalbum.Exporter
+	if format := r.URL.Query().Get("format"); format != "" && format != "json" {
+		var err error
+		exporter, err = icloudalbum.NewExporter(format)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid format", err.Error())
+			return
+		}
+	}
+
 	log.Printf("DEBUG: Requesting album with key: %s", key)
 
-	// Create iCloud client and fetch images
-	client := icloudalbum.NewClient()
-	log.Printf("DEBUG: Created iCloud client, calling GetImages...")
+	// Fetch images
+	log.Printf("DEBUG: Calling GetImages...")
 
-	response, err := client.GetImages(key)
+	cached, err := albumResponses.get(ctx, key)
 	if err != nil {
 		log.Printf("DEBUG: GetImages returned ERROR: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		sendError(w, http.StatusInternalServerError, "Failed to fetch album", err.Error())
 		return
 	}
+	w.Header().Set("X-Cache", cached.Status)
+	response := cached.Response
 
 	log.Printf("DEBUG: GetImages completed successfully")
 	log.Printf("DEBUG: Response metadata - StreamName: %s, UserFirstName: %s, ItemsReturned: %d",
@@ -106,50 +216,37 @@ func getAlbumHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("DEBUG: Found %d photos in response", len(response.Photos))
 
-	// Convert photos to ImageResponse format
-	imageResponses := make([]ImageResponse, 0, len(response.Photos))
-	
-	for _, photo := range response.Photos {
-		// Find the full size image (largest file size)
-		var fullImage *icloudalbum.Derivative
-		for _, derivative := range photo.Derivatives {
-			if fullImage == nil || derivative.FileSize > fullImage.FileSize {
-				fullImage = &derivative
-			}
-		}
+	// Answer conditional requests before building the body
+	if setCacheHeaders(w, r, response) {
+		log.Printf("Album key %s not modified", key)
+		return
+	}
 
-		// Find the thumbnail (smallest file size)
-		var thumbnail *icloudalbum.Derivative
-		for _, derivative := range photo.Derivatives {
-			if thumbnail == nil || derivative.FileSize < thumbnail.FileSize {
-				thumbnail = &derivative
+	if exporter != nil {
+		// Locations are only known from the EXIF metadata
+		if _, ok := exporter.(icloudalbum.GeoJSONExporter); ok {
+			if !enrichEXIF(ctx, key, response.Photos) {
+				return
 			}
 		}
 
-		// Determine asset type
-		assetType := "image"
-		if photo.MediaAssetType != nil && *photo.MediaAssetType == "video" {
-			assetType = "video"
-		}
-
-		// Get URLs, defaulting to empty string if not available
-		fullImageURL := ""
-		if fullImage != nil && fullImage.URL != nil {
-			fullImageURL = *fullImage.URL
-		}
-
-		thumbnailURL := ""
-		if thumbnail != nil && thumbnail.URL != nil {
-			thumbnailURL = *thumbnail.URL
+		w.Header().Set("Content-Type", exporter.ContentType())
+		if err := exporter.Export(w, response); err != nil {
+			log.Printf("Error exporting response: %v", err)
+			return
 		}
+		log.Printf("Successfully exported %d photos for album key: %s", len(response.Photos), key)
+		return
+	}
 
-		imageResponse := ImageResponse{
-			Caption:      photo.Caption,
-			FullImageURL: fullImageURL,
-			ThumbnailURL: thumbnailURL,
-			AssetType:    assetType,
+	// Convert photos to ImageResponse format
+	imageResponses := make([]ImageResponse, 0, len(response.Photos))
+	
+	for _, photo := range response.Photos {
+		imageResponse := newImageResponse(photo)
+		if responsive {
+			addResponsiveData(&imageResponse, photo, sizes)
 		}
-
 		imageResponses = append(imageResponses, imageResponse)
 	}
 
@@ -169,6 +266,290 @@ func getAlbumHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Successfully served %d photos for album key: %s", len(imageResponses), key)
 }
 
+// newImageResponse converts a photo to the simplified response format
+func newImageResponse(photo icloudalbum.Image) ImageResponse {
+	// Find the full size image (largest file size)
+	var fullImage *icloudalbum.Derivative
+	for _, derivative := range photo.Derivatives {
+		if fullImage == nil || derivative.FileSize > fullImage.FileSize {
+			fullImage = &derivative
+		}
+	}
+
+	// Find the thumbnail (smallest file size)
+	var thumbnail *icloudalbum.Derivative
+	for _, derivative := range photo.Derivatives {
+		if thumbnail == nil || derivative.FileSize < thumbnail.FileSize {
+			thumbnail = &derivative
+		}
+	}
+
+	// Determine asset type
+	assetType := "image"
+	if photo.MediaAssetType != nil && *photo.MediaAssetType == "video" {
+		assetType = "video"
+	}
+
+	// Get URLs, defaulting to empty string if not available
+	fullImageURL := ""
+	if fullImage != nil && fullImage.URL != nil {
+		fullImageURL = *fullImage.URL
+	}
+
+	thumbnailURL := ""
+	if thumbnail != nil && thumbnail.URL != nil {
+		thumbnailURL = *thumbnail.URL
+	}
+
+	return ImageResponse{
+		Caption:      photo.Caption,
+		FullImageURL: fullImageURL,
+		ThumbnailURL: thumbnailURL,
+		AssetType:    assetType,
+	}
+}
+
+// addResponsiveData adds all derivatives of photo and, for images, a
+// srcset and sizes hint to imageResponse. Without a sizes hint the image
+// is assumed to span the viewport up to its largest width.
+func addResponsiveData(imageResponse *ImageResponse, photo icloudalbum.Image, sizes string) {
+	maxWidth := 0
+	for key, derivative := range photo.Derivatives {
+		if derivative.URL == nil {
+			continue
+		}
+		imageResponse.Derivatives = append(imageResponse.Derivatives, DerivativeResponse{
+			Key:      key,
+			URL:      *derivative.URL,
+			Width:    derivative.Width,
+			Height:   derivative.Height,
+			FileSize: derivative.FileSize,
+		})
+		if derivative.Width > maxWidth {
+			maxWidth = derivative.Width
+		}
+	}
+	sort.Slice(imageResponse.Derivatives, func(i, j int) bool {
+		a, b := imageResponse.Derivatives[i], imageResponse.Derivatives[j]
+		if a.Width != b.Width {
+			return a.Width < b.Width
+		}
+		return a.Key < b.Key
+	})
+
+	// Video derivatives are not interchangeable image sources
+	if imageResponse.AssetType == "video" {
+		return
+	}
+
+	imageResponse.Srcset = icloudalbum.Srcset(photo)
+	if imageResponse.Srcset == "" {
+		return
+	}
+	if sizes == "" {
+		sizes = fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", maxWidth, maxWidth)
+	}
+	imageResponse.Sizes = sizes
+}
+
+func getAlbumDetailedHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startRequestSpan(r, "GET /album/{key}/detailed")
+	defer span.End()
+
+	key := mux.Vars(r)["key"]
+	if key == "" {
+		sendError(w, http.StatusBadRequest, "Missing album key", "Album key is required")
+		return
+	}
+
+	withEXIF, _ := strconv.ParseBool(r.URL.Query().Get("exif"))
+
+	log.Printf("DEBUG: Requesting detailed album with key: %s (exif: %t)", key, withEXIF)
+
+	cached, err := albumResponses.get(ctx, key)
+	if err != nil {
+		log.Printf("DEBUG: GetImages returned ERROR: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		sendError(w, http.StatusInternalServerError, "Failed to fetch album", err.Error())
+		return
+	}
+	w.Header().Set("X-Cache", cached.Status)
+	response := cached.Response
+
+	if len(response.Photos) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if withEXIF && !enrichEXIF(ctx, key, response.Photos) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+		return
+	}
+
+	log.Printf("Successfully served %d detailed photos for album key: %s", len(response.Photos), key)
+}
+
+func getAlbumStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startRequestSpan(r, "GET /album/{key}/stats")
+	defer span.End()
+
+	key := mux.Vars(r)["key"]
+	if key == "" {
+		sendError(w, http.StatusBadRequest, "Missing album key", "Album key is required")
+		return
+	}
+
+	log.Printf("DEBUG: Requesting stats for album with key: %s", key)
+
+	cached, err := albumResponses.get(ctx, key)
+	if err != nil {
+		log.Printf("DEBUG: GetImages returned ERROR: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		sendError(w, http.StatusInternalServerError, "Failed to fetch album", err.Error())
+		return
+	}
+	w.Header().Set("X-Cache", cached.Status)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(icloudalbum.Stats(cached.Response)); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+		return
+	}
+
+	log.Printf("Successfully served stats for album key: %s", key)
+}
+
+func getAlbumTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startRequestSpan(r, "GET /album/{key}/timeline")
+	defer span.End()
+
+	key := mux.Vars(r)["key"]
+	if key == "" {
+		sendError(w, http.StatusBadRequest, "Missing album key", "Album key is required")
+		return
+	}
+
+	by := icloudalbum.ByDay
+	if param := r.URL.Query().Get("by"); param != "" {
+		var err error
+		if by, err = icloudalbum.ParseGranularity(param); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid grouping", err.Error())
+			return
+		}
+	}
+
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid timezone", err.Error())
+			return
+		}
+	}
+
+	log.Printf("DEBUG: Requesting timeline by %s for album with key: %s", by, key)
+
+	cached, err := albumResponses.get(ctx, key)
+	if err != nil {
+		log.Printf("DEBUG: GetImages returned ERROR: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		sendError(w, http.StatusInternalServerError, "Failed to fetch album", err.Error())
+		return
+	}
+	w.Header().Set("X-Cache", cached.Status)
+	response := cached.Response
+
+	if len(response.Photos) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	groups := icloudalbum.Timeline(response, by, loc)
+
+	groupResponses := make([]TimelineGroupResponse, 0, len(groups))
+	for _, group := range groups {
+		groupResponse := TimelineGroupResponse{
+			Key:    group.Key,
+			Count:  group.Count,
+			Photos: group.Photos,
+			Videos: group.Videos,
+			Cover:  newImageResponse(group.Cover),
+		}
+		if !group.Start.IsZero() {
+			groupResponse.Start = &group.Start
+			groupResponse.End = &group.End
+		}
+		groupResponses = append(groupResponses, groupResponse)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groupResponses); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+		return
+	}
+
+	log.Printf("Successfully served %d timeline groups for album key: %s", len(groupResponses), key)
+}
+
+func getAlbumGeoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startRequestSpan(r, "GET /album/{key}/geo.json")
+	defer span.End()
+
+	key := mux.Vars(r)["key"]
+	if key == "" {
+		sendError(w, http.StatusBadRequest, "Missing album key", "Album key is required")
+		return
+	}
+
+	log.Printf("DEBUG: Requesting GeoJSON for album with key: %s", key)
+
+	cached, err := albumResponses.get(ctx, key)
+	if err != nil {
+		log.Printf("DEBUG: GetImages returned ERROR: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		sendError(w, http.StatusInternalServerError, "Failed to fetch album", err.Error())
+		return
+	}
+	w.Header().Set("X-Cache", cached.Status)
+	response := cached.Response
+
+	if !enrichEXIF(ctx, key, response.Photos) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	if err := json.NewEncoder(w).Encode(icloudalbum.GeoJSON(response, nil)); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+		return
+	}
+
+	log.Printf("Successfully served GeoJSON for album key: %s", key)
+}
+
+// enrichEXIF adds EXIF metadata to photos. Photos whose metadata cannot be
+// read are served without it; it returns false only if the request was
+// cancelled.
+func enrichEXIF(ctx context.Context, key string, photos []icloudalbum.Image) bool {
+	err := albumClient.EnrichEXIF(ctx, photos)
+	if err == nil {
+		return true
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	log.Printf("Error reading EXIF metadata of album key %s, serving photos without it: %v", key, err)
+	return true
+}
+
 func sendError(w http.ResponseWriter, statusCode int, error string, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"container/list"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+)
+
+// mediaCache is a content-addressed store of proxied media on disk. Files
+// are named by derivative checksum, so they never go stale, and the least
+// recently used files are evicted once the store exceeds maxBytes.
+type mediaCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+	// filling holds the checksums currently being written
+	filling map[string]bool
+}
+
+type mediaEntry struct {
+	checksum string
+	// name is the file name, the checksum plus an extension telling the
+	// content type
+	name string
+	size int64
+}
+
+// validChecksum guards against path traversal through checksums
+var validChecksum = regexp.MustCompile(`^[A-Za-z0-9_-]{8,128}$`)
+
+// mediaFiles is created in main from the configuration
+var mediaFiles *mediaCache
+
+// newMediaCache indexes the files already present in dir, ordered by their
+// modification time, which is updated on every access
+func newMediaCache(dir string, maxBytes int64) *mediaCache {
+	c := &mediaCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		filling:  make(map[string]bool),
+	}
+	if maxBytes <= 0 {
+		return c
+	}
+
+	type found struct {
+		entry   *mediaEntry
+		modTime time.Time
+	}
+	var files []found
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, ".") {
+			// Leftover of an interrupted write
+			os.Remove(path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		checksum := strings.TrimSuffix(name, filepath.Ext(name))
+		if !validChecksum.MatchString(checksum) {
+			return nil
+		}
+		files = append(files, found{&mediaEntry{checksum: checksum, name: name, size: info.Size()}, info.ModTime()})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error indexing media cache: %v", err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	for _, file := range files {
+		c.entries[file.entry.checksum] = c.lru.PushBack(file.entry)
+		c.size += file.entry.size
+	}
+	c.evict()
+
+	log.Printf("Media cache holds %d files (%d bytes) in %s", len(c.entries), c.size, dir)
+	return c
+}
+
+func (c *mediaCache) enabled() bool {
+	return c.maxBytes > 0
+}
+
+// path returns the location of a cached file, spread over subdirectories
+// by the first characters of the checksum
+func (c *mediaCache) path(name string) string {
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// open returns the cached file for checksum and its name, or nil if it is
+// not cached. The caller must close the file.
+func (c *mediaCache) open(checksum string) (*os.File, string) {
+	if !c.enabled() {
+		return nil, ""
+	}
+
+	c.mu.Lock()
+	elem, ok := c.entries[checksum]
+	if !ok {
+		c.mu.Unlock()
+		return nil, ""
+	}
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*mediaEntry)
+	c.mu.Unlock()
+
+	path := c.path(entry.name)
+	file, err := os.Open(path)
+	if err != nil {
+		// Removed behind our back
+		c.mu.Lock()
+		if elem, ok := c.entries[checksum]; ok {
+			c.remove(elem)
+		}
+		c.mu.Unlock()
+		return nil, ""
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return file, entry.name
+}
+
+// begin starts writing checksum to the cache. It returns nil if the file
+// is not cacheable or already being written.
+func (c *mediaCache) begin(checksum, contentType, rawURL string) *mediaFill {
+	if !c.enabled() || !validChecksum.MatchString(checksum) {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[checksum]; ok || c.filling[checksum] {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(c.dir, checksum[:2]), 0o755); err != nil {
+		log.Printf("Error creating media cache directory: %v", err)
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, checksum[:2]), ".fill-*")
+	if err != nil {
+		log.Printf("Error creating media cache file: %v", err)
+		return nil
+	}
+
+	c.filling[checksum] = true
+	return &mediaFill{
+		cache:    c,
+		checksum: checksum,
+		name:     checksum + "." + icloudalbum.DetectExtension(nil, contentType, rawURL),
+		tmp:      tmp,
+	}
+}
+
+// fill downloads a derivative into the cache in the background, for
+// requests that could not be written through, such as ranges
+func (c *mediaCache) fill(checksum string, open func() (*http.Response, error)) {
+	if !c.enabled() || !validChecksum.MatchString(checksum) {
+		return
+	}
+
+	go func() {
+		resp, err := open()
+		if err != nil {
+			log.Printf("Error filling media cache for %s: %v", checksum, err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return
+		}
+
+		fill := c.begin(checksum, resp.Header.Get("Content-Type"), resp.Request.URL.Path)
+		if fill == nil {
+			return
+		}
+		if _, err := io.Copy(fill, resp.Body); err != nil {
+			fill.abort()
+			return
+		}
+		fill.commit(resp.ContentLength)
+	}()
+}
+
+// store adds a committed file, evicting older files beyond maxBytes; the
+// caller must hold c.mu
+func (c *mediaCache) store(entry *mediaEntry) {
+	if elem, ok := c.entries[entry.checksum]; ok {
+		c.remove(elem)
+	}
+	c.entries[entry.checksum] = c.lru.PushFront(entry)
+	c.size += entry.size
+	c.evict()
+}
+
+// evict removes the least recently used files beyond maxBytes; the caller
+// must hold c.mu
+func (c *mediaCache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		elem := c.lru.Back()
+		entry := elem.Value.(*mediaEntry)
+		c.remove(elem)
+		if err := os.Remove(c.path(entry.name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error evicting %s from media cache: %v", entry.name, err)
+		}
+	}
+}
+
+// remove drops elem from the index; the caller must hold c.mu
+func (c *mediaCache) remove(elem *list.Element) {
+	entry := elem.Value.(*mediaEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.checksum)
+	c.size -= entry.size
+}
+
+// mediaFill is a file being written to the cache. It only becomes visible
+// once committed, so readers never see partial files.
+type mediaFill struct {
+	cache    *mediaCache
+	checksum string
+	name     string
+	tmp      *os.File
+	written  int64
+	failed   bool
+}
+
+func (f *mediaFill) Write(p []byte) (int, error) {
+	if f.failed {
+		return len(p), nil
+	}
+	n, err := f.tmp.Write(p)
+	f.written += int64(n)
+	if err != nil {
+		// A full disk must not break the response being proxied
+		log.Printf("Error writing media cache file: %v", err)
+		f.failed = true
+	}
+	return len(p), nil
+}
+
+// commit moves the file into place if it is complete. expected is the
+// Content-Length of the download, or -1 if unknown.
+func (f *mediaFill) commit(expected int64) {
+	if f.failed || (expected >= 0 && f.written != expected) {
+		f.abort()
+		return
+	}
+
+	c := f.cache
+	err := f.tmp.Close()
+	if err == nil {
+		err = os.Rename(f.tmp.Name(), c.path(f.name))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.filling, f.checksum)
+	if err != nil {
+		log.Printf("Error committing media cache file: %v", err)
+		os.Remove(f.tmp.Name())
+		return
+	}
+	if f.written > c.maxBytes {
+		os.Remove(c.path(f.name))
+		return
+	}
+	c.store(&mediaEntry{checksum: f.checksum, name: f.name, size: f.written})
+}
+
+// abort discards the file
+func (f *mediaFill) abort() {
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+
+	f.cache.mu.Lock()
+	delete(f.cache.filling, f.checksum)
+	f.cache.mu.Unlock()
+}
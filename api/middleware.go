@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/cors"
+)
+
+// reloadableCORS applies the CORS policy of the current configuration; rs/cors
+// handlers are immutable, so a new one is built on every reload
+type reloadableCORS struct {
+	next    http.Handler
+	handler atomic.Pointer[http.Handler]
+}
+
+var corsHandler = &reloadableCORS{}
+
+// wrap sets the handler the CORS policy is applied to
+func (c *reloadableCORS) wrap(next http.Handler) http.Handler {
+	c.next = next
+	c.update(config().CORS)
+	return c
+}
+
+func (c *reloadableCORS) update(cfg CORSConfig) {
+	if c.next == nil {
+		return
+	}
+	handler := cors.New(cors.Options{
+		AllowedOrigins: cfg.AllowedOrigins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"ETag", "X-Cache"},
+	}).Handler(c.next)
+	c.handler.Store(&handler)
+}
+
+func (c *reloadableCORS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*c.handler.Load()).ServeHTTP(w, r)
+}
+
+// resolveAlbumAlias replaces an album alias in the URL with the album key it
+// stands for, before any handler sees it
+func resolveAlbumAlias(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if key, ok := config().Albums[vars["key"]]; ok {
+			vars["key"] = key
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireFeature answers with 404 Not Found while the feature name is
+// turned off in the configuration
+func requireFeature(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !config().Features.enabled(name) {
+			sendError(w, http.StatusNotFound, "Feature disabled", "The "+name+" feature is turned off on this server")
+			return
+		}
+		next(w, r)
+	}
+}
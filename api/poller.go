@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+)
+
+// albumPoller runs a single Watcher per album key and fans its events out
+// to all subscribers, so any number of consumers watching the same album
+// cause one upstream poll
+type albumPoller struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	watches map[string]*albumWatch
+}
+
+type albumWatch struct {
+	cancel      context.CancelFunc
+	subscribers map[chan icloudalbum.Event]struct{}
+}
+
+// poller is created in main from the configuration
+var poller *albumPoller
+
+func newAlbumPoller(interval time.Duration) *albumPoller {
+	return &albumPoller{interval: interval, watches: make(map[string]*albumWatch)}
+}
+
+// subscribe returns a channel receiving the change events of album key and
+// a function to cancel the subscription. Polling starts with the first and
+// stops with the last subscriber of an album.
+func (p *albumPoller) subscribe(key string) (<-chan icloudalbum.Event, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	watch, ok := p.watches[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		watch = &albumWatch{cancel: cancel, subscribers: make(map[chan icloudalbum.Event]struct{})}
+		p.watches[key] = watch
+
+		watcher := &icloudalbum.Watcher{
+			Client:      albumClient,
+			Tokens:      []string{key},
+			Interval:    p.interval,
+			ResolveURLs: true,
+		}
+		go p.fanOut(key, watch, watcher.Watch(ctx))
+		log.Printf("Started polling album key: %s", key)
+	}
+
+	events := make(chan icloudalbum.Event, 64)
+	watch.subscribers[events] = struct{}{}
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() { p.unsubscribe(key, events) })
+	}
+}
+
+func (p *albumPoller) unsubscribe(key string, events chan icloudalbum.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	watch, ok := p.watches[key]
+	if !ok {
+		return
+	}
+	delete(watch.subscribers, events)
+	close(events)
+
+	if len(watch.subscribers) == 0 {
+		watch.cancel()
+		delete(p.watches, key)
+		log.Printf("Stopped polling album key: %s", key)
+	}
+}
+
+// fanOut forwards events to the subscribers of watch. Events are dropped
+// for subscribers that do not keep up rather than delaying the others.
+func (p *albumPoller) fanOut(key string, watch *albumWatch, events <-chan icloudalbum.Event) {
+	for event := range events {
+		if event.Type == icloudalbum.AlbumUnavailable {
+			log.Printf("Album key %s became unavailable: %v", key, event.Err)
+		}
+
+		p.mu.Lock()
+		for subscriber := range watch.subscribers {
+			select {
+			case subscriber <- event:
+			default:
+				log.Printf("Dropping %s event for slow subscriber of album key: %s", event.Type, key)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
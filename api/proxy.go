@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// proxiedHeaders are copied from iCloud's response to the client
+var proxiedHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified"}
+
+// getPhotoProxyHandler streams a derivative of a photo from iCloud, so
+// pages never embed the expiring signed URLs. Range requests are passed
+// through for video seeking. With resize parameters, a resized variant of
+// the derivative is served instead.
+func getPhotoProxyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startRequestSpan(r, "GET /album/{key}/photo/{guid}/{derivative}")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	key, guid := vars["key"], vars["guid"]
+
+	opts, err := parseResizeOptions(r.URL.Query())
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid resize parameters", err.Error())
+		return
+	}
+	if opts != nil && !config().Features.Resize {
+		sendError(w, http.StatusNotFound, "Feature disabled", "The resize feature is turned off on this server")
+		return
+	}
+
+	cached, err := albumResponses.get(ctx, key)
+	if err != nil {
+		log.Printf("DEBUG: GetImages returned ERROR: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		sendError(w, http.StatusInternalServerError, "Failed to fetch album", err.Error())
+		return
+	}
+
+	var photo *icloudalbum.Image
+	for i := range cached.Response.Photos {
+		if cached.Response.Photos[i].PhotoGUID == guid {
+			photo = &cached.Response.Photos[i]
+			break
+		}
+	}
+	if photo == nil {
+		sendError(w, http.StatusNotFound, "Photo not found", fmt.Sprintf("album has no photo %s", guid))
+		return
+	}
+
+	derivativeKey, derivative, ok := proxyDerivative(*photo, vars["derivative"])
+	if !ok {
+		sendError(w, http.StatusNotFound, "Derivative not found", fmt.Sprintf("photo %s has no derivative %q", guid, vars["derivative"]))
+		return
+	}
+
+	// The checksum identifies the bytes, so the response can be cached for
+	// good when the client asks for a specific version
+	if r.URL.Query().Get("v") == derivative.Checksum {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+
+	if opts != nil {
+		err := serveResized(ctx, w, r, key, *photo, derivativeKey, derivative, opts)
+		switch {
+		case errors.Is(err, errUnsupportedMedia):
+			sendError(w, http.StatusUnsupportedMediaType, "Cannot resize media", fmt.Sprintf("derivative %s of photo %s is not a supported image", derivativeKey, guid))
+		case err != nil:
+			log.Printf("Error resizing %s/%s: %v", guid, derivativeKey, err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			sendError(w, http.StatusBadGateway, "Failed to fetch media", err.Error())
+		}
+		return
+	}
+
+	etag := `"` + derivative.Checksum + `"`
+	w.Header().Set("ETag", etag)
+	if notModified(r, etag, time.Time{}) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Serve from the disk cache, which also answers Range requests
+	if file, name := mediaFiles.open(derivative.Checksum); file != nil {
+		defer file.Close()
+		w.Header().Set("X-Cache", cacheHit)
+		http.ServeContent(w, r, name, time.Time{}, file)
+		return
+	}
+	w.Header().Set("X-Cache", cacheMiss)
+
+	// Only honour Range if the client's copy is still current
+	header := http.Header{}
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		if ifRange := r.Header.Get("If-Range"); ifRange == "" || ifRange == etag {
+			header.Set("Range", rangeHeader)
+		}
+	}
+
+	resp, err := openDerivative(ctx, key, *photo, derivativeKey, derivative, header)
+	if err != nil {
+		log.Printf("Error proxying %s/%s: %v", guid, derivativeKey, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		sendError(w, http.StatusBadGateway, "Failed to fetch media", err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+	default:
+		sendError(w, http.StatusBadGateway, "Failed to fetch media", "iCloud responded with "+resp.Status)
+		return
+	}
+
+	for _, name := range proxiedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType == "" || contentType == "application/octet-stream" {
+		w.Header().Set("Content-Type", mediaType(resp.Request.URL.Path))
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	// Write complete downloads through to the disk cache; partial ones are
+	// fetched in full in the background
+	var body io.Reader = resp.Body
+	var fill *mediaFill
+	switch {
+	case resp.StatusCode == http.StatusOK && r.Method != http.MethodHead:
+		fill = mediaFiles.begin(derivative.Checksum, resp.Header.Get("Content-Type"), resp.Request.URL.Path)
+		if fill != nil {
+			body = io.TeeReader(resp.Body, fill)
+		}
+	case resp.StatusCode == http.StatusPartialContent:
+		mediaFiles.fill(derivative.Checksum, func() (*http.Response, error) {
+			return openDerivative(context.Background(), key, *photo, derivativeKey, derivative, nil)
+		})
+	}
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, err = io.Copy(w, body)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Error streaming %s/%s: %v", guid, derivativeKey, err)
+	}
+	if fill != nil {
+		if err != nil {
+			fill.abort()
+		} else {
+			fill.commit(resp.ContentLength)
+		}
+	}
+}
+
+// openDerivative requests a derivative from iCloud. URLs from the album
+// cache may have expired, so a rejected request is retried once with a
+// freshly resolved URL.
+func openDerivative(ctx context.Context, key string, photo icloudalbum.Image, derivativeKey string, derivative icloudalbum.Derivative, header http.Header) (*http.Response, error) {
+	if derivative.URL != nil {
+		resp, err := albumClient.OpenURL(ctx, *derivative.URL, header)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+			resp.Body.Close()
+		default:
+			return resp, nil
+		}
+	}
+
+	urls, err := albumClient.ResolveURLsContext(ctx, key, []string{photo.PhotoGUID})
+	if err != nil {
+		return nil, err
+	}
+	rawURL, ok := urls[derivative.Checksum]
+	if !ok {
+		return nil, fmt.Errorf("no URL for derivative %s of photo %s", derivativeKey, photo.PhotoGUID)
+	}
+	return albumClient.OpenURL(ctx, rawURL, header)
+}
+
+// proxyDerivative selects the derivative named in the URL, either by its
+// key or as "largest" or "smallest"
+func proxyDerivative(photo icloudalbum.Image, name string) (string, icloudalbum.Derivative, bool) {
+	switch name {
+	case "largest":
+		return icloudalbum.LargestDerivative(photo)
+	case "smallest":
+		return icloudalbum.SmallestDerivative(photo)
+	}
+	derivative, ok := photo.Derivatives[name]
+	return name, derivative, ok
+}
+
+// mediaType guesses the content type of a derivative from its URL path
+func mediaType(urlPath string) string {
+	if t := mime.TypeByExtension(strings.ToLower(path.Ext(urlPath))); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+	"github.com/Shogoki/icloud-shared-album-go/exif"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// maxResizeSize bounds the requested width and height
+	maxResizeSize = 4096
+	// maxSourceBytes and maxSourcePixels bound the images decoded for
+	// resizing, guarding against decompression bombs
+	maxSourceBytes  = 64 << 20
+	maxSourcePixels = 100_000_000
+	defaultQuality  = 85
+)
+
+// errUnsupportedMedia is returned for derivatives that cannot be decoded,
+// such as videos
+var errUnsupportedMedia = errors.New("media cannot be resized")
+
+// resizeSlots limits the number of images decoded and encoded at once
+var resizeSlots = make(chan struct{}, runtime.NumCPU())
+
+// resizeOptions describes a variant of a derivative requested with
+// ?w=&h=&fit=&format=&q=
+type resizeOptions struct {
+	width, height int
+	// fit is "contain" to fit within width and height, or "cover" to fill
+	// them and crop the overflow around the centre
+	fit string
+	// format is jpeg or png; empty keeps the source format
+	format  string
+	quality int
+	// qualitySet reports whether quality was requested with q
+	qualitySet bool
+}
+
+// parseResizeOptions reads the resize parameters of a request. It returns
+// nil if the request asks for the derivative as is.
+func parseResizeOptions(query url.Values) (*resizeOptions, error) {
+	if query.Get("w") == "" && query.Get("h") == "" && query.Get("fit") == "" &&
+		query.Get("format") == "" && query.Get("q") == "" {
+		return nil, nil
+	}
+
+	opts := &resizeOptions{fit: "contain", quality: defaultQuality}
+	var err error
+	if opts.width, err = queryInt(query, "w", 0, 1, maxResizeSize); err != nil {
+		return nil, err
+	}
+	if opts.height, err = queryInt(query, "h", 0, 1, maxResizeSize); err != nil {
+		return nil, err
+	}
+	if opts.quality, err = queryInt(query, "q", defaultQuality, 1, 100); err != nil {
+		return nil, err
+	}
+	opts.qualitySet = query.Get("q") != ""
+
+	switch fit := query.Get("fit"); fit {
+	case "":
+	case "contain", "cover":
+		opts.fit = fit
+	default:
+		return nil, fmt.Errorf("fit must be cover or contain, got %q", fit)
+	}
+	if opts.fit == "cover" && (opts.width == 0 || opts.height == 0) {
+		return nil, errors.New("fit=cover requires both w and h")
+	}
+
+	// There is no WebP output: the pure Go encoders are lossless only,
+	// which makes photos larger than JPEG, and libwebp needs cgo
+	switch format := query.Get("format"); format {
+	case "", "png":
+		opts.format = format
+	case "jpeg", "jpg":
+		opts.format = "jpeg"
+	default:
+		return nil, fmt.Errorf("format must be jpeg or png, got %q", format)
+	}
+	return opts, nil
+}
+
+// queryInt parses an optional integer query parameter within min and max
+func queryInt(query url.Values, name string, fallback, min, max int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be a number between %d and %d", name, min, max)
+	}
+	return n, nil
+}
+
+// variantKey names the variant of a derivative in the media cache, so it
+// is only produced once per checksum and parameters
+func (o *resizeOptions) variantKey(checksum, format string) string {
+	key := fmt.Sprintf("%s_%dx%d_%s_%s", checksum, o.width, o.height, o.fit, format)
+	if format == "jpeg" {
+		key += "_q" + strconv.Itoa(o.quality)
+	}
+	return key
+}
+
+// serveResized sends a resized variant of a derivative, producing and
+// caching it first if needed
+func serveResized(ctx context.Context, w http.ResponseWriter, r *http.Request, key string, photo icloudalbum.Image, derivativeKey string, derivative icloudalbum.Derivative, opts *resizeOptions) error {
+	// Keep the source format unless asked otherwise. iCloud derivatives are
+	// JPEG unless their URL says otherwise; WebP sources become PNG, which
+	// keeps their transparency.
+	format := opts.format
+	if format == "" {
+		format = "jpeg"
+		if derivative.URL != nil {
+			if u, err := url.Parse(*derivative.URL); err == nil {
+				switch mediaType(u.Path) {
+				case "image/png", "image/webp":
+					format = "png"
+				}
+			}
+		}
+	}
+
+	variant := opts.variantKey(derivative.Checksum, format)
+	etag := `"` + variant + `"`
+	w.Header().Set("ETag", etag)
+	if notModified(r, etag, time.Time{}) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	if file, name := mediaFiles.open(variant); file != nil {
+		defer file.Close()
+		w.Header().Set("X-Cache", cacheHit)
+		http.ServeContent(w, r, name, time.Time{}, file)
+		return nil
+	}
+
+	source, err := derivativeBytes(ctx, key, photo, derivativeKey, derivative)
+	if err != nil {
+		return err
+	}
+
+	resizeSlots <- struct{}{}
+	img, err := resizeImage(source, opts)
+	var encoded bytes.Buffer
+	if err == nil {
+		err = encodeImage(&encoded, img, format, opts.quality)
+	}
+	<-resizeSlots
+	if err != nil {
+		return err
+	}
+
+	contentType := "image/" + format
+	if fill := mediaFiles.begin(variant, contentType, ""); fill != nil {
+		fill.Write(encoded.Bytes())
+		fill.commit(int64(encoded.Len()))
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Cache", cacheMiss)
+	http.ServeContent(w, r, variant+"."+icloudalbum.DetectExtension(nil, contentType, ""), time.Time{}, bytes.NewReader(encoded.Bytes()))
+	return nil
+}
+
+// derivativeBytes returns the content of a derivative, from the media cache
+// if possible. Downloads are added to the cache.
+func derivativeBytes(ctx context.Context, key string, photo icloudalbum.Image, derivativeKey string, derivative icloudalbum.Derivative) ([]byte, error) {
+	if file, _ := mediaFiles.open(derivative.Checksum); file != nil {
+		defer file.Close()
+		return readSource(file)
+	}
+
+	// Tell videos by their URL before downloading them
+	if derivative.URL != nil {
+		if u, err := url.Parse(*derivative.URL); err == nil && !decodableType(mediaType(u.Path)) {
+			return nil, errUnsupportedMedia
+		}
+	}
+
+	resp, err := openDerivative(ctx, key, photo, derivativeKey, derivative, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("iCloud responded with %s", resp.Status)
+	}
+	if !decodableType(resp.Header.Get("Content-Type")) || resp.ContentLength > maxSourceBytes {
+		return nil, errUnsupportedMedia
+	}
+
+	data, err := readSource(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if fill := mediaFiles.begin(derivative.Checksum, resp.Header.Get("Content-Type"), resp.Request.URL.Path); fill != nil {
+		fill.Write(data)
+		fill.commit(resp.ContentLength)
+	}
+	return data, nil
+}
+
+// decodableType reports whether media of contentType may be an image that
+// can be resized. Unknown types are tried.
+func decodableType(contentType string) bool {
+	return !strings.HasPrefix(contentType, "video/") && !strings.HasPrefix(contentType, "audio/")
+}
+
+// readSource reads an image of at most maxSourceBytes
+func readSource(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSourceBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSourceBytes {
+		return nil, errUnsupportedMedia
+	}
+	return data, nil
+}
+
+// resizeImage decodes source, rotates it upright according to its EXIF
+// orientation and scales it as described by opts. Images are never
+// enlarged.
+func resizeImage(source []byte, opts *resizeOptions) (image.Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(source))
+	if err != nil || config.Width*config.Height > maxSourcePixels {
+		return nil, errUnsupportedMedia
+	}
+	img, _, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		return nil, errUnsupportedMedia
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		if data, err := exif.Decode(bytes.NewReader(source)); err == nil {
+			orientation = data.Orientation
+		}
+	}
+	img = orient(img, orientation)
+
+	src := img.Bounds()
+	srcW, srcH := src.Dx(), src.Dy()
+	dstW, dstH := srcW, srcH
+
+	switch {
+	case opts.fit == "cover":
+		// Crop the largest centred area with the requested aspect ratio
+		cropW, cropH := srcW, srcH
+		if srcW*opts.height > srcH*opts.width {
+			cropW = max(1, srcH*opts.width/opts.height)
+		} else {
+			cropH = max(1, srcW*opts.height/opts.width)
+		}
+		src = image.Rect(0, 0, cropW, cropH).Add(src.Min).Add(image.Pt((srcW-cropW)/2, (srcH-cropH)/2))
+		dstW, dstH = cropW, cropH
+		if cropW > opts.width {
+			dstW, dstH = opts.width, opts.height
+		}
+	case opts.width > 0 || opts.height > 0:
+		scale := 1.0
+		if opts.width > 0 {
+			scale = min(scale, float64(opts.width)/float64(srcW))
+		}
+		if opts.height > 0 {
+			scale = min(scale, float64(opts.height)/float64(srcH))
+		}
+		dstW = max(1, int(float64(srcW)*scale+0.5))
+		dstH = max(1, int(float64(srcH)*scale+0.5))
+	}
+
+	if src == img.Bounds() && dstW == srcW && dstH == srcH {
+		return img, nil
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst, nil
+}
+
+// orient applies an EXIF orientation, returning the image as it should be
+// displayed
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // Rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // Transposed
+				sx, sy = y, x
+			case 6: // Rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // Transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // Rotated 90° counter-clockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
+
+// encodeImage writes img as jpeg or png. quality only applies to JPEG.
+func encodeImage(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	default:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseResizeOptions(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    *resizeOptions
+		wantErr bool
+	}{
+		{query: "", want: nil},
+		{query: "v=abc", want: nil},
+		{query: "w=300", want: &resizeOptions{width: 300, fit: "contain", quality: defaultQuality}},
+		{query: "w=300&h=200&fit=cover&format=jpg&q=70", want: &resizeOptions{width: 300, height: 200, fit: "cover", format: "jpeg", quality: 70, qualitySet: true}},
+		{query: "format=png&q=50", want: &resizeOptions{fit: "contain", format: "png", quality: 50, qualitySet: true}},
+		{query: "format=webp", wantErr: true},
+		{query: "w=0", wantErr: true},
+		{query: "w=5000", wantErr: true},
+		{query: "q=101", wantErr: true},
+		{query: "fit=cover&w=100", wantErr: true},
+		{query: "fit=fill", wantErr: true},
+		{query: "format=gif", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			got, err := parseResizeOptions(query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseResizeOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("parseResizeOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodableType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"image/jpeg", true},
+		{"image/heic", true},
+		{"application/octet-stream", true},
+		{"", true},
+		{"video/mp4", false},
+		{"video/quicktime", false},
+		{"audio/mpeg", false},
+	}
+
+	for _, tt := range tests {
+		if got := decodableType(tt.contentType); got != tt.want {
+			t.Errorf("decodableType(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+// labelled returns an image whose pixels are labelled by letters, row by
+// row, in their red channel
+func labelled(rows ...string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, label := range row {
+			img.Set(x, y, color.RGBA{R: uint8(label), A: 255})
+		}
+	}
+	return img
+}
+
+// labels returns the rows of an image created by labelled
+func labels(img image.Image) []string {
+	b := img.Bounds()
+	rows := make([]string, 0, b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row strings.Builder
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, _, _, _ := img.At(x, y).RGBA()
+			row.WriteByte(byte(r >> 8))
+		}
+		rows = append(rows, row.String())
+	}
+	return rows
+}
+
+func TestOrient(t *testing.T) {
+	tests := []struct {
+		orientation int
+		want        []string
+	}{
+		{0, []string{"abc", "def"}},
+		{1, []string{"abc", "def"}},
+		{2, []string{"cba", "fed"}},
+		{3, []string{"fed", "cba"}},
+		{4, []string{"def", "abc"}},
+		{5, []string{"ad", "be", "cf"}},
+		{6, []string{"da", "eb", "fc"}},
+		{7, []string{"fc", "eb", "da"}},
+		{8, []string{"cf", "be", "ad"}},
+		{9, []string{"abc", "def"}},
+	}
+
+	for _, tt := range tests {
+		img := labelled("abc", "def")
+		got := labels(orient(img, tt.orientation))
+		if strings.Join(got, "/") != strings.Join(tt.want, "/") {
+			t.Errorf("orient(%d) = %q, want %q", tt.orientation, got, tt.want)
+		}
+	}
+}
+
+// jpegWithOrientation encodes a blank JPEG of width and height with an EXIF
+// orientation
+func jpegWithOrientation(t *testing.T, width, height, orientation int) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Big endian TIFF header and an IFD with only the orientation
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = append(tiff, 0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(orientation), 0x00, 0x00)
+	tiff = append(tiff, 0x00, 0x00, 0x00, 0x00)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(2+6+len(tiff)))
+	app1 = append(append(app1, "Exif\x00\x00"...), tiff...)
+
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+func TestResizeImage(t *testing.T) {
+	var landscape bytes.Buffer
+	if err := png.Encode(&landscape, image.NewRGBA(image.Rect(0, 0, 400, 300))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source []byte
+		opts   resizeOptions
+		want   image.Point
+	}{
+		{"format only", landscape.Bytes(), resizeOptions{fit: "contain"}, image.Pt(400, 300)},
+		{"contain width", landscape.Bytes(), resizeOptions{width: 200, fit: "contain"}, image.Pt(200, 150)},
+		{"contain height", landscape.Bytes(), resizeOptions{height: 30, fit: "contain"}, image.Pt(40, 30)},
+		{"contain both", landscape.Bytes(), resizeOptions{width: 100, height: 100, fit: "contain"}, image.Pt(100, 75)},
+		{"contain never enlarges", landscape.Bytes(), resizeOptions{width: 800, height: 800, fit: "contain"}, image.Pt(400, 300)},
+		{"cover crops width", landscape.Bytes(), resizeOptions{width: 100, height: 100, fit: "cover"}, image.Pt(100, 100)},
+		{"cover crops height", landscape.Bytes(), resizeOptions{width: 200, height: 50, fit: "cover"}, image.Pt(200, 50)},
+		{"cover never enlarges", landscape.Bytes(), resizeOptions{width: 1000, height: 500, fit: "cover"}, image.Pt(400, 200)},
+		{"EXIF orientation", jpegWithOrientation(t, 400, 300, 6), resizeOptions{width: 150, fit: "contain"}, image.Pt(150, 200)},
+		{"EXIF orientation cover", jpegWithOrientation(t, 400, 300, 8), resizeOptions{width: 100, height: 50, fit: "cover"}, image.Pt(100, 50)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := resizeImage(tt.source, &tt.opts)
+			if err != nil {
+				t.Fatalf("resizeImage() error = %v", err)
+			}
+			if got := img.Bounds().Size(); got != tt.want {
+				t.Errorf("resizeImage() size = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResizeImageUnsupported(t *testing.T) {
+	if _, err := resizeImage([]byte("not an image"), &resizeOptions{fit: "contain"}); err != errUnsupportedMedia {
+		t.Errorf("resizeImage() error = %v, want %v", err, errUnsupportedMedia)
+	}
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	// webhookMaxAttempts is the number of times a delivery is attempted
+	webhookMaxAttempts = 5
+	// webhookBackoff is the delay before the first retry, doubled for
+	// every further attempt
+	webhookBackoff = 2 * time.Second
+	// webhookMaxDeliveries is the number of deliveries kept in the log of
+	// every subscription
+	webhookMaxDeliveries = 100
+)
+
+// errWebhookTarget is returned for webhook URLs on private, loopback or
+// link-local addresses
+var errWebhookTarget = errors.New("webhook target address is not public")
+
+// WebhookSubscription is a URL notified about changes of an album
+type WebhookSubscription struct {
+	ID       string `json:"id"`
+	AlbumKey string `json:"albumKey"`
+	URL      string `json:"url"`
+	// Secret signs the payloads. It is only returned when the
+	// subscription is created.
+	Secret    string                  `json:"secret,omitempty"`
+	Events    []icloudalbum.EventType `json:"events"`
+	CreatedAt time.Time               `json:"createdAt"`
+}
+
+// WebhookDelivery is a payload sent to a subscription
+type WebhookDelivery struct {
+	ID             string                `json:"id"`
+	SubscriptionID string                `json:"subscriptionId"`
+	Event          icloudalbum.EventType `json:"event"`
+	Payload        json.RawMessage       `json:"payload"`
+	// ReplayOf is the ID of the delivery this one replays
+	ReplayOf  string            `json:"replayOf,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Delivered bool              `json:"delivered"`
+	Attempts  []DeliveryAttempt `json:"attempts"`
+}
+
+// DeliveryAttempt is a single try to deliver a payload
+type DeliveryAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+// EventPayload describes an album change, posted to webhooks and sent as
+// server-sent events
+type EventPayload struct {
+	ID        string                `json:"id"`
+	Event     icloudalbum.EventType `json:"event"`
+	AlbumKey  string                `json:"albumKey"`
+	Time      time.Time             `json:"time"`
+	PhotoGUID string                `json:"photoGuid,omitempty"`
+	Photo     *ImageResponse        `json:"photo,omitempty"`
+	Old       string                `json:"old,omitempty"`
+	New       string                `json:"new,omitempty"`
+}
+
+// webhookRequest is the body of a subscription registration
+type webhookRequest struct {
+	URL    string                  `json:"url"`
+	Secret string                  `json:"secret"`
+	Events []icloudalbum.EventType `json:"events"`
+}
+
+// webhookState is the persisted part of the webhook store
+type webhookState struct {
+	Subscriptions map[string]*WebhookSubscription `json:"subscriptions"`
+	// Deliveries are keyed by subscription ID, oldest first
+	Deliveries map[string][]*WebhookDelivery `json:"deliveries"`
+}
+
+// webhookStore keeps subscriptions and delivery logs and dispatches album
+// events to them
+type webhookStore struct {
+	path   string
+	client *http.Client
+
+	mu    sync.Mutex
+	state webhookState
+	// listeners cancel the poller subscriptions, keyed by album key
+	listeners map[string]func()
+}
+
+// webhooks is created in main, in the configured data directory
+var webhooks *webhookStore
+
+func newWebhookStore(path string) *webhookStore {
+	s := &webhookStore{
+		path:   path,
+		client: newWebhookClient(),
+		state: webhookState{
+			Subscriptions: make(map[string]*WebhookSubscription),
+			Deliveries:    make(map[string][]*WebhookDelivery),
+		},
+		listeners: make(map[string]func()),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading webhooks: %v", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		log.Printf("Error parsing webhooks %s: %v", path, err)
+	}
+	if s.state.Subscriptions == nil {
+		s.state.Subscriptions = make(map[string]*WebhookSubscription)
+	}
+	if s.state.Deliveries == nil {
+		s.state.Deliveries = make(map[string][]*WebhookDelivery)
+	}
+	return s
+}
+
+// newWebhookClient returns an HTTP client that refuses to connect to
+// addresses that are not public, so webhooks cannot reach internal
+// services, also not through DNS changes or redirects
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s", errWebhookTarget, host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would connect on our behalf, bypassing the address check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// publicIP reports whether webhooks may be delivered to ip
+func publicIP(ip net.IP) bool {
+	return !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// checkWebhookHost resolves host and fails unless all of its addresses are
+// public
+func checkWebhookHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", errWebhookTarget, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// start begins watching every album with subscriptions
+func (s *webhookStore) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.state.Subscriptions {
+		s.listen(sub.AlbumKey)
+	}
+}
+
+// listen subscribes to the events of album key unless already subscribed;
+// the caller must hold s.mu
+func (s *webhookStore) listen(key string) {
+	if _, ok := s.listeners[key]; ok {
+		return
+	}
+
+	events, cancel := poller.subscribe(key)
+	s.listeners[key] = cancel
+	go func() {
+		for event := range events {
+			s.dispatch(key, event)
+		}
+	}()
+}
+
+// save writes the state to disk; the caller must hold s.mu
+func (s *webhookStore) save() {
+	if err := writeJSONFile(s.path, s.state, 0o600); err != nil {
+		log.Printf("Error saving webhooks: %v", err)
+	}
+}
+
+func (s *webhookStore) add(sub *WebhookSubscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.Subscriptions[sub.ID] = sub
+	s.save()
+	s.listen(sub.AlbumKey)
+}
+
+func (s *webhookStore) remove(key, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.state.Subscriptions[id]
+	if !ok || sub.AlbumKey != key {
+		return false
+	}
+	delete(s.state.Subscriptions, id)
+	delete(s.state.Deliveries, id)
+	s.save()
+
+	// Stop watching the album once nobody is subscribed anymore
+	for _, other := range s.state.Subscriptions {
+		if other.AlbumKey == key {
+			return true
+		}
+	}
+	if cancel, ok := s.listeners[key]; ok {
+		cancel()
+		delete(s.listeners, key)
+	}
+	return true
+}
+
+// subscriptions returns the subscriptions of album key without secrets
+func (s *webhookStore) subscriptions(key string) []WebhookSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subs := []WebhookSubscription{}
+	for _, sub := range s.state.Subscriptions {
+		if sub.AlbumKey == key {
+			public := *sub
+			public.Secret = ""
+			subs = append(subs, public)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+	return subs
+}
+
+// deliveries returns a copy of the delivery log of subscription id on
+// album key, newest first
+func (s *webhookStore) deliveries(key, id string) ([]WebhookDelivery, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.state.Subscriptions[id]
+	if !ok || sub.AlbumKey != key {
+		return nil, false
+	}
+
+	history := s.state.Deliveries[id]
+	deliveries := make([]WebhookDelivery, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		delivery := *history[i]
+		delivery.Attempts = append([]DeliveryAttempt(nil), history[i].Attempts...)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, true
+}
+
+// dispatch delivers event to every subscription of album key interested in
+// its type. Events are dropped while webhooks are turned off.
+func (s *webhookStore) dispatch(key string, event icloudalbum.Event) {
+	if !config().Features.Webhooks {
+		return
+	}
+
+	payload := newEventPayload(key, event)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding webhook payload: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enqueued := false
+	for _, sub := range s.state.Subscriptions {
+		if sub.AlbumKey != key || !sub.wants(event.Type) {
+			continue
+		}
+		s.enqueue(sub, &WebhookDelivery{
+			ID:             newID(),
+			SubscriptionID: sub.ID,
+			Event:          event.Type,
+			Payload:        body,
+			CreatedAt:      time.Now(),
+		})
+		enqueued = true
+	}
+	if enqueued {
+		s.save()
+	}
+}
+
+// newEventPayload converts an event of album key to its JSON form
+func newEventPayload(key string, event icloudalbum.Event) EventPayload {
+	payload := EventPayload{
+		ID:       newID(),
+		Event:    event.Type,
+		AlbumKey: key,
+		Time:     event.Time,
+		Old:      event.Old,
+		New:      event.New,
+	}
+	if event.Photo != nil {
+		photo := newImageResponse(*event.Photo)
+		payload.PhotoGUID = event.Photo.PhotoGUID
+		payload.Photo = &photo
+	}
+	return payload
+}
+
+// replay delivers the payload of an earlier delivery again
+func (s *webhookStore) replay(key, id, deliveryID string) (*WebhookDelivery, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.state.Subscriptions[id]
+	if !ok || sub.AlbumKey != key {
+		return nil, false
+	}
+	for _, original := range s.state.Deliveries[id] {
+		if original.ID != deliveryID {
+			continue
+		}
+		delivery := &WebhookDelivery{
+			ID:             newID(),
+			SubscriptionID: id,
+			Event:          original.Event,
+			Payload:        original.Payload,
+			ReplayOf:       original.ID,
+			CreatedAt:      time.Now(),
+		}
+		s.enqueue(sub, delivery)
+		s.save()
+		copied := *delivery
+		return &copied, true
+	}
+	return nil, false
+}
+
+// enqueue logs delivery and sends it in the background; the caller must
+// hold s.mu and save the state afterwards
+func (s *webhookStore) enqueue(sub *WebhookSubscription, delivery *WebhookDelivery) {
+	history := append(s.state.Deliveries[sub.ID], delivery)
+	if len(history) > webhookMaxDeliveries {
+		history = history[len(history)-webhookMaxDeliveries:]
+	}
+	s.state.Deliveries[sub.ID] = history
+
+	go s.deliver(*sub, delivery)
+}
+
+// deliver posts the payload of delivery to sub, retrying with exponential
+// backoff until it is accepted with a 2xx status
+func (s *webhookStore) deliver(sub WebhookSubscription, delivery *WebhookDelivery) {
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		result := s.post(sub, delivery)
+		delivered := result.Error == "" && result.StatusCode >= 200 && result.StatusCode < 300
+
+		s.mu.Lock()
+		_, subscribed := s.state.Subscriptions[sub.ID]
+		delivery.Attempts = append(delivery.Attempts, result)
+		delivery.Delivered = delivered
+		if subscribed {
+			s.save()
+		}
+		s.mu.Unlock()
+
+		if delivered || !subscribed {
+			return
+		}
+		log.Printf("Webhook delivery %s to %s failed (attempt %d/%d): status %d %s",
+			delivery.ID, sub.URL, attempt, webhookMaxAttempts, result.StatusCode, result.Error)
+
+		if attempt < webhookMaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// post sends a single delivery attempt
+func (s *webhookStore) post(sub WebhookSubscription, delivery *WebhookDelivery) DeliveryAttempt {
+	attempt := DeliveryAttempt{Time: time.Now()}
+
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "icloud-api-go-webhooks")
+	req.Header.Set("X-Webhook-ID", sub.ID)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID)
+	req.Header.Set("X-Webhook-Event", string(delivery.Event))
+	req.Header.Set("X-Webhook-Signature-256", "sha256="+sign(sub.Secret, delivery.Payload))
+
+	resp, err := s.client.Do(req)
+	attempt.DurationMs = time.Since(attempt.Time).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	resp.Body.Close()
+	attempt.StatusCode = resp.StatusCode
+	return attempt
+}
+
+// wants reports whether sub is interested in events of type t
+func (sub *WebhookSubscription) wants(t icloudalbum.EventType) bool {
+	for _, wanted := range sub.Events {
+		if wanted == t {
+			return true
+		}
+	}
+	return false
+}
+
+// sign returns the hex encoded HMAC-SHA256 of body with secret
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newID returns a random identifier
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+var webhookEventTypes = map[icloudalbum.EventType]bool{
+	icloudalbum.PhotoAdded:       true,
+	icloudalbum.PhotoRemoved:     true,
+	icloudalbum.CaptionChanged:   true,
+	icloudalbum.AlbumRenamed:     true,
+	icloudalbum.AlbumUnavailable: true,
+}
+
+// requireWebhookAuth protects the webhook endpoints with the configured
+// admin token. Without a token the endpoints are refused, since webhooks
+// make the server send requests to any URL.
+func requireWebhookAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := config().Webhooks.AdminToken
+		if token == "" {
+			sendError(w, http.StatusForbidden, "Webhooks not configured", "Set WEBHOOK_ADMIN_TOKEN to use the webhook endpoints")
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			sendError(w, http.StatusUnauthorized, "Unauthorized", "A valid bearer token is required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startRequestSpan(r, "POST /album/{key}/webhooks")
+	defer span.End()
+
+	key := mux.Vars(r)["key"]
+
+	var request webhookRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&request); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	target, err := url.Parse(request.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		sendError(w, http.StatusBadRequest, "Invalid webhook URL", "url must be an absolute http or https URL")
+		return
+	}
+	if err := checkWebhookHost(ctx, target.Hostname()); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid webhook URL", err.Error())
+		return
+	}
+
+	events := request.Events
+	if len(events) == 0 {
+		events = []icloudalbum.EventType{icloudalbum.PhotoAdded}
+	}
+	for _, event := range events {
+		if !webhookEventTypes[event] {
+			sendError(w, http.StatusBadRequest, "Invalid event type", fmt.Sprintf("unknown event type %q", event))
+			return
+		}
+	}
+
+	// Only watch albums that exist
+	if _, err := albumResponses.get(ctx, key); err != nil {
+		log.Printf("DEBUG: GetImages returned ERROR: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		sendError(w, http.StatusNotFound, "Album not found", err.Error())
+		return
+	}
+
+	secret := request.Secret
+	if secret == "" {
+		secret = newID() + newID()
+	}
+
+	sub := &WebhookSubscription{
+		ID:        newID(),
+		AlbumKey:  key,
+		URL:       target.String(),
+		Secret:    secret,
+		Events:    events,
+		CreatedAt: time.Now().UTC(),
+	}
+	webhooks.add(sub)
+	log.Printf("Registered webhook %s for album key: %s", sub.ID, key)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(sub); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+func listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := startRequestSpan(r, "GET /album/{key}/webhooks")
+	defer span.End()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(webhooks.subscriptions(mux.Vars(r)["key"])); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := startRequestSpan(r, "DELETE /album/{key}/webhooks/{id}")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	if !webhooks.remove(vars["key"], vars["id"]) {
+		sendError(w, http.StatusNotFound, "Webhook not found", fmt.Sprintf("no webhook %s for this album", vars["id"]))
+		return
+	}
+	log.Printf("Removed webhook %s for album key: %s", vars["id"], vars["key"])
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := startRequestSpan(r, "GET /album/{key}/webhooks/{id}/deliveries")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	deliveries, ok := webhooks.deliveries(vars["key"], vars["id"])
+	if !ok {
+		sendError(w, http.StatusNotFound, "Webhook not found", fmt.Sprintf("no webhook %s for this album", vars["id"]))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+func replayWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := startRequestSpan(r, "POST /album/{key}/webhooks/{id}/deliveries/{delivery}/replay")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	delivery, ok := webhooks.replay(vars["key"], vars["id"], vars["delivery"])
+	if !ok {
+		sendError(w, http.StatusNotFound, "Delivery not found", fmt.Sprintf("no delivery %s for webhook %s", vars["delivery"], vars["id"]))
+		return
+	}
+	log.Printf("Replaying delivery %s of webhook %s as %s", vars["delivery"], vars["id"], delivery.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(delivery); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
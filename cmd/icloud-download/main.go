@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory to download to")
+	name := flag.String("name", icloudalbum.DefaultFilenameTemplate, "file name template, e.g. {date:2006-01-02}_{contributor}_{guid}_{derivative}.{ext}")
+	derivative := flag.String("derivative", "largest", "derivative to download: largest or smallest")
+	overwrite := flag.Bool("overwrite", false, "download files that already exist again")
+	sidecar := flag.Bool("xmp", false, "write XMP sidecar files")
+	embed := flag.Bool("embed", false, "embed caption, contributor and date into JPEG files")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <token>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if _, err := icloudalbum.ParseFilenameTemplate(*name); err != nil {
+		log.Fatalf("Invalid file name template: %v", err)
+	}
+
+	downloader := &icloudalbum.Downloader{
+		Dir:           *dir,
+		Filename:      *name,
+		Overwrite:     *overwrite,
+		XMPSidecar:    *sidecar,
+		EmbedMetadata: *embed,
+	}
+	switch *derivative {
+	case "largest":
+		downloader.Derivative = icloudalbum.LargestDerivative
+	case "smallest":
+		downloader.Derivative = icloudalbum.SmallestDerivative
+	default:
+		log.Fatalf("Unknown derivative %q", *derivative)
+	}
+
+	client := icloudalbum.NewClient()
+	downloader.Client = client
+
+	response, err := client.GetImages(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Error getting images: %v", err)
+	}
+
+	files, err := downloader.Download(context.Background(), response)
+	if err != nil {
+		log.Fatalf("Error downloading: %v", err)
+	}
+
+	downloaded := 0
+	for _, file := range files {
+		if !file.Skipped {
+			downloaded++
+			fmt.Println(file.Path)
+		}
+	}
+	fmt.Printf("Downloaded %d files, %d already present\n", downloaded, len(files)-downloaded)
+}
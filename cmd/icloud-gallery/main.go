@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+	"github.com/Shogoki/icloud-shared-album-go/gallery"
+)
+
+func main() {
+	out := flag.String("out", "gallery", "output directory")
+	title := flag.String("title", "", "gallery title (defaults to the album name)")
+	download := flag.Bool("download", false, "download images instead of linking to iCloud")
+	themeDir := flag.String("theme", "", "directory with templates overriding the default theme")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <token>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	token := flag.Arg(0)
+
+	opts := gallery.Options{
+		Title:    *title,
+		Download: *download,
+	}
+	if *themeDir != "" {
+		theme, err := gallery.ParseTheme(os.DirFS(*themeDir))
+		if err != nil {
+			log.Fatalf("Error loading theme: %v", err)
+		}
+		opts.Theme = theme
+	}
+
+	client := icloudalbum.NewClient()
+	opts.Client = client
+
+	response, err := client.GetImages(token)
+	if err != nil {
+		log.Fatalf("Error getting images: %v", err)
+	}
+
+	if err := gallery.Generate(context.Background(), response, *out, opts); err != nil {
+		log.Fatalf("Error generating gallery: %v", err)
+	}
+
+	fmt.Printf("Gallery with %d photos written to %s\n", len(response.Photos), *out)
+}
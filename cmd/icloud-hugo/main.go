@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+	"github.com/Shogoki/icloud-shared-album-go/hugo"
+)
+
+func main() {
+	site := flag.String("site", ".", "root directory of the Hugo site")
+	dataDir := flag.String("data", "data/albums", "data directory, relative to the site")
+	contentDir := flag.String("content", "content/albums", "content directory for page bundles, relative to the site")
+	format := flag.String("format", hugo.FormatJSON, "data and front matter format: json, yaml or toml")
+	bundle := flag.Bool("bundle", false, "also write a page bundle with downloaded images")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <token>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client := icloudalbum.NewClient()
+	for _, token := range flag.Args() {
+		response, err := client.GetImages(token)
+		if err != nil {
+			log.Fatalf("Error getting images: %v", err)
+		}
+
+		opts := hugo.Options{Format: *format, Client: client}
+
+		path, err := hugo.WriteData(response, filepath.Join(*site, *dataDir), opts)
+		if err != nil {
+			log.Fatalf("Error writing data file: %v", err)
+		}
+		fmt.Printf("Wrote %d photos to %s\n", len(response.Photos), path)
+
+		if *bundle {
+			dir, err := hugo.WriteBundle(context.Background(), response, filepath.Join(*site, *contentDir), opts)
+			if err != nil {
+				log.Fatalf("Error writing page bundle: %v", err)
+			}
+			fmt.Printf("Wrote page bundle to %s\n", dir)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+)
+
+func main() {
+	dir := flag.String("dir", "snapshots", "directory snapshots are stored in, in a subdirectory per album")
+	asJSON := flag.Bool("json", false, "print the diff as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <token>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	token := flag.Arg(0)
+	albumDir := icloudalbum.AlbumSnapshotDir(*dir, token)
+
+	// Only the metadata is kept; download URLs expire and would show up as
+	// changes on every run
+	client := icloudalbum.NewClient()
+	response, err := client.GetAlbum(token)
+	if err != nil {
+		log.Fatalf("Error getting album: %v", err)
+	}
+	snapshot := icloudalbum.NewSnapshot(response, time.Now())
+
+	// Compare against the latest snapshot before saving the new one
+	var previous *icloudalbum.Snapshot
+	paths, err := icloudalbum.ListSnapshots(albumDir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Error listing snapshots: %v", err)
+	}
+	if len(paths) > 0 {
+		if previous, err = icloudalbum.LoadSnapshot(paths[len(paths)-1]); err != nil {
+			log.Fatalf("Error loading snapshot: %v", err)
+		}
+	}
+
+	path := icloudalbum.SnapshotPath(albumDir, snapshot.FetchedAt)
+	if err := icloudalbum.SaveSnapshot(path, snapshot); err != nil {
+		log.Fatalf("Error saving snapshot: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Saved %d photos to %s\n", len(snapshot.Photos), path)
+
+	if previous == nil {
+		return
+	}
+	diff := icloudalbum.Diff(previous, snapshot)
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(diff); err != nil {
+			log.Fatalf("Error encoding diff: %v", err)
+		}
+		return
+	}
+
+	if diff.Empty() {
+		fmt.Printf("No changes since %s\n", diff.From.Format(time.RFC3339))
+		return
+	}
+	fmt.Printf("Changes since %s:\n", diff.From.Format(time.RFC3339))
+	if diff.OldName != diff.NewName {
+		fmt.Printf("  renamed %q to %q\n", diff.OldName, diff.NewName)
+	}
+	for _, photo := range diff.Added {
+		fmt.Printf("  + %s %s\n", photo.PhotoGUID, photo.Caption)
+	}
+	for _, photo := range diff.Removed {
+		fmt.Printf("  - %s %s\n", photo.PhotoGUID, photo.Caption)
+	}
+	for _, change := range diff.CaptionChanges {
+		fmt.Printf("  ~ %s caption %q -> %q\n", change.PhotoGUID, change.Old, change.New)
+	}
+	for _, change := range diff.DerivativeChanges {
+		switch {
+		case change.Old == nil:
+			fmt.Printf("  ~ %s derivative %s added\n", change.PhotoGUID, change.Key)
+		case change.New == nil:
+			fmt.Printf("  ~ %s derivative %s removed\n", change.PhotoGUID, change.Key)
+		default:
+			fmt.Printf("  ~ %s derivative %s replaced\n", change.PhotoGUID, change.Key)
+		}
+	}
+}
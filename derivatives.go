@@ -0,0 +1,104 @@
+package icloudalbum
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DerivativeSelector picks a single derivative of a photo, returning its
+// key in Image.Derivatives. ok is false if the photo has no derivatives.
+type DerivativeSelector func(photo Image) (key string, derivative Derivative, ok bool)
+
+// LargestDerivative selects the derivative with the largest file size,
+// usually the full-size original.
+func LargestDerivative(photo Image) (string, Derivative, bool) {
+	return selectDerivative(photo, func(a, b Derivative) bool { return a.FileSize > b.FileSize })
+}
+
+// SmallestDerivative selects the derivative with the smallest file size,
+// usually the thumbnail.
+func SmallestDerivative(photo Image) (string, Derivative, bool) {
+	return selectDerivative(photo, func(a, b Derivative) bool { return a.FileSize < b.FileSize })
+}
+
+// selectDerivative returns the derivative for which better reports true
+// against all others. Keys are visited in sorted order so ties are broken
+// deterministically.
+func selectDerivative(photo Image, better func(a, b Derivative) bool) (string, Derivative, bool) {
+	keys := make([]string, 0, len(photo.Derivatives))
+	for key := range photo.Derivatives {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var (
+		bestKey string
+		best    Derivative
+		found   bool
+	)
+	for _, key := range keys {
+		derivative := photo.Derivatives[key]
+		if !found || better(derivative, best) {
+			bestKey, best, found = key, derivative, true
+		}
+	}
+	return bestKey, best, found
+}
+
+// BestFitDerivative returns a selector choosing the smallest derivative
+// that fills a width x height display when scaled to fit within it, i.e.
+// that is at least as wide or at least as tall as the display. If no
+// derivative is large enough, the largest one is selected.
+func BestFitDerivative(width, height int) DerivativeSelector {
+	return func(photo Image) (string, Derivative, bool) {
+		key, derivative, ok := selectDerivative(photo, func(a, b Derivative) bool {
+			aFits, bFits := fits(a, width, height), fits(b, width, height)
+			if aFits != bFits {
+				return aFits
+			}
+			if aFits {
+				return pixels(a) < pixels(b)
+			}
+			return pixels(a) > pixels(b)
+		})
+		return key, derivative, ok
+	}
+}
+
+func fits(d Derivative, width, height int) bool {
+	return d.Width >= width || d.Height >= height
+}
+
+func pixels(d Derivative) int {
+	return d.Width * d.Height
+}
+
+// Srcset returns an HTML srcset attribute value listing every derivative
+// of photo that has a URL, with its width as descriptor, from narrowest to
+// widest. Of several derivatives with the same width only the smallest file
+// is listed.
+func Srcset(photo Image) string {
+	byWidth := make(map[int]Derivative)
+	for _, derivative := range photo.Derivatives {
+		if derivative.URL == nil || derivative.Width <= 0 {
+			continue
+		}
+		if existing, ok := byWidth[derivative.Width]; ok && existing.FileSize <= derivative.FileSize {
+			continue
+		}
+		byWidth[derivative.Width] = derivative
+	}
+
+	widths := make([]int, 0, len(byWidth))
+	for width := range byWidth {
+		widths = append(widths, width)
+	}
+	sort.Ints(widths)
+
+	candidates := make([]string, 0, len(widths))
+	for _, width := range widths {
+		candidates = append(candidates, *byWidth[width].URL+" "+strconv.Itoa(width)+"w")
+	}
+	return strings.Join(candidates, ", ")
+}
@@ -0,0 +1,566 @@
+package icloudalbum
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Shogoki/icloud-shared-album-go/xmp"
+)
+
+// Downloader saves derivatives of album photos to a local directory
+type Downloader struct {
+	// Client is used to fetch the files. Defaults to a new Client.
+	Client *Client
+
+	// Dir is the directory files are written to
+	Dir string
+
+	// Derivative chooses the derivative downloaded by Download.
+	// Defaults to LargestDerivative.
+	Derivative DerivativeSelector
+
+	// Overwrite re-downloads files that were downloaded before. Files in
+	// Dir that belong to other photos are never overwritten.
+	Overwrite bool
+
+	// EXIF reads the EXIF metadata of every downloaded file into the
+	// Image.EXIF of the returned DownloadedFile
+	EXIF bool
+
+	// XMPSidecar writes an XMP sidecar (photo.jpg.xmp) with the caption,
+	// contributor and creation date next to every downloaded file
+	XMPSidecar bool
+
+	// EmbedMetadata embeds the caption, contributor and creation date into
+	// downloaded JPEG files as XMP and IPTC metadata
+	EmbedMetadata bool
+
+	// Filename is the template used to name files, see FilenameTemplate.
+	// Defaults to DefaultFilenameTemplate. Different photos rendering to
+	// the same name get a numeric suffix, so include {guid} if names must
+	// be stable across album changes.
+	Filename string
+
+	mu       sync.Mutex
+	template *FilenameTemplate
+	// used maps the names of files in Dir to the derivatives they belong
+	// to, as recorded in the manifest and reserved during this download
+	used  map[string]manifestEntry
+	dirty bool
+}
+
+// ManifestName is the file in a Downloader's Dir recording which photo
+// derivative every downloaded file belongs to
+const ManifestName = ".icloud-download.json"
+
+// manifestEntry records the derivative a downloaded file belongs to
+type manifestEntry struct {
+	// Owner is the photo GUID and derivative key, separated by a slash
+	Owner string `json:"owner"`
+	// Checksum is the checksum of the derivative when it was downloaded
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// DownloadedFile describes a derivative saved to disk
+type DownloadedFile struct {
+	Photo         Image
+	DerivativeKey string
+	Derivative    Derivative
+	// Path is the location of the file, relative to the Downloader's Dir
+	Path string
+	// Skipped is true if the file already existed and was not downloaded
+	Skipped bool
+}
+
+// Download saves the selected derivative of every photo in resp. Photos
+// whose derivatives have no URL are skipped.
+func (d *Downloader) Download(ctx context.Context, resp *Response) (_ []DownloadedFile, err error) {
+	defer func() {
+		if saveErr := d.saveManifest(); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	selectDerivative := d.Derivative
+	if selectDerivative == nil {
+		selectDerivative = LargestDerivative
+	}
+
+	files := make([]DownloadedFile, 0, len(resp.Photos))
+	for _, photo := range resp.Photos {
+		key, derivative, ok := selectDerivative(photo)
+		if !ok || derivative.URL == nil {
+			continue
+		}
+
+		file, err := d.downloadDerivative(ctx, photo, key, resp.Metadata.StreamName)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// DownloadDerivative saves the derivative key of photo
+func (d *Downloader) DownloadDerivative(ctx context.Context, photo Image, key string) (_ DownloadedFile, err error) {
+	defer func() {
+		if saveErr := d.saveManifest(); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	return d.downloadDerivative(ctx, photo, key, "")
+}
+
+func (d *Downloader) downloadDerivative(ctx context.Context, photo Image, key, album string) (DownloadedFile, error) {
+	derivative, ok := photo.Derivatives[key]
+	if !ok {
+		return DownloadedFile{}, fmt.Errorf("photo %s has no derivative %q", photo.PhotoGUID, key)
+	}
+	if derivative.URL == nil {
+		return DownloadedFile{}, fmt.Errorf("derivative %q of photo %s has no URL", key, photo.PhotoGUID)
+	}
+
+	file := DownloadedFile{
+		Photo:         photo,
+		DerivativeKey: key,
+		Derivative:    derivative,
+	}
+	data := FilenameData{
+		Photo:         photo,
+		DerivativeKey: key,
+		Derivative:    derivative,
+		Album:         album,
+		Ext:           DetectExtension(nil, "", *derivative.URL),
+	}
+
+	// Name the file with the extension it was saved with before, or the
+	// one from the URL, so existing files are found without downloading
+	// them again
+	if ext, ok := d.previousExt(data); ok {
+		data.Ext = ext
+	}
+	name, err := d.reserveName(data)
+	if err != nil {
+		return file, err
+	}
+	file.Path = filepath.FromSlash(name)
+
+	target := filepath.Join(d.Dir, file.Path)
+	existing, exists := findExisting(target, data.Ext)
+	if exists && existing != target {
+		// The file may have been renamed after a previous download; it is
+		// only ours if that name is not reserved by another derivative
+		data.Ext = strings.TrimPrefix(filepath.Ext(existing), ".")
+		if name, err = d.reserveName(data); err != nil {
+			return file, err
+		}
+		file.Path = filepath.FromSlash(name)
+		target = filepath.Join(d.Dir, file.Path)
+		_, err := os.Stat(target)
+		exists = err == nil
+	}
+
+	// Files of an older version of the derivative are replaced
+	stale := exists && !d.current(name, derivative.Checksum)
+	if exists && !d.Overwrite && !stale {
+		file.Skipped = true
+	} else {
+		if err := os.MkdirAll(d.Dir, 0o755); err != nil {
+			return file, fmt.Errorf("creating download directory: %w", err)
+		}
+
+		client := d.Client
+		if client == nil {
+			client = NewClient()
+		}
+		download, err := client.downloadTemp(ctx, *derivative.URL, d.Dir)
+		if err != nil {
+			return file, fmt.Errorf("downloading %s: %w", file.Path, err)
+		}
+		defer os.Remove(download.path)
+
+		// Rename the file if its content tells a different type
+		if ext := DetectExtension(download.header, download.contentType, *derivative.URL); ext != data.Ext {
+			data.Ext = ext
+			if name, err = d.reserveName(data); err != nil {
+				return file, err
+			}
+			file.Path = filepath.FromSlash(name)
+			target = filepath.Join(d.Dir, file.Path)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return file, fmt.Errorf("creating download directory: %w", err)
+		}
+		// Names are reserved against the files in Dir, so another file
+		// can only appear here if it was created in the meantime
+		if _, err := os.Lstat(target); err == nil && !d.Overwrite && !stale {
+			return file, fmt.Errorf("downloading %s: file already exists", file.Path)
+		}
+		if err := os.Rename(download.path, target); err != nil {
+			return file, err
+		}
+		d.recordChecksum(name, derivative.Checksum)
+
+		if d.EmbedMetadata {
+			err := xmp.EmbedFile(target, xmpMetadata(photo, album))
+			if err != nil && !errors.Is(err, xmp.ErrNotJPEG) {
+				return file, fmt.Errorf("embedding metadata into %s: %w", file.Path, err)
+			}
+		}
+	}
+
+	if d.XMPSidecar {
+		sidecar := xmp.SidecarPath(target)
+		if _, err := os.Stat(sidecar); err != nil || !file.Skipped {
+			if err := xmp.WriteSidecar(target, xmpMetadata(photo, album)); err != nil {
+				return file, fmt.Errorf("writing XMP sidecar for %s: %w", file.Path, err)
+			}
+		}
+	}
+
+	if d.EXIF && !isVideo(photo) {
+		exifData, err := ReadEXIF(target)
+		if err != nil {
+			return file, fmt.Errorf("reading EXIF of %s: %w", file.Path, err)
+		}
+		file.Photo.EXIF = exifData
+	}
+
+	return file, nil
+}
+
+// findExisting looks for target, or a file differing from it only in the
+// extension ext, as downloaded files are renamed when their content does
+// not match the extension in the URL.
+func findExisting(target, ext string) (string, bool) {
+	if _, err := os.Stat(target); err == nil {
+		return target, true
+	}
+	if ext == "" || !strings.HasSuffix(target, "."+ext) {
+		return "", false
+	}
+
+	base := filepath.Base(strings.TrimSuffix(target, ext))
+	entries, err := os.ReadDir(filepath.Dir(target))
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, base) {
+			continue
+		}
+		other := name[len(base):]
+		if other != "" && !strings.ContainsAny(other, ".") && other != "xmp" {
+			return filepath.Join(filepath.Dir(target), name), true
+		}
+	}
+	return "", false
+}
+
+// reserveName renders the file name for data, adding a numeric suffix if
+// the name belongs to another derivative, either in the manifest or as a
+// file in Dir that is not known to belong to this one.
+func (d *Downloader) reserveName(data FilenameData) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.init(); err != nil {
+		return "", err
+	}
+
+	owner := data.Photo.PhotoGUID + "/" + data.DerivativeKey
+	name := d.template.Execute(data)
+	if name == "" {
+		return "", fmt.Errorf("filename template %q produced an empty name for %s", d.template, owner)
+	}
+
+	// Forget names reserved for the same derivative with another extension
+	for reserved, entry := range d.used {
+		if entry.Owner == owner && reserved != name {
+			delete(d.used, reserved)
+			d.dirty = true
+		}
+	}
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; ; i++ {
+		if entry, ok := d.used[candidate]; ok {
+			if entry.Owner == owner {
+				return candidate, nil
+			}
+		} else if d.available(candidate, data.Photo.PhotoGUID) {
+			break
+		}
+		candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+
+	d.used[candidate] = manifestEntry{Owner: owner}
+	d.dirty = true
+	return candidate, nil
+}
+
+// previousExt returns the extension of the file the derivative of data was
+// saved to before under the name the template renders, as its content may
+// have told a different type than the URL
+func (d *Downloader) previousExt(data FilenameData) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.init(); err != nil {
+		return "", false
+	}
+
+	owner := data.Photo.PhotoGUID + "/" + data.DerivativeKey
+	name := d.template.Execute(data)
+	base := strings.TrimSuffix(name, path.Ext(name))
+	for reserved, entry := range d.used {
+		if entry.Owner != owner {
+			continue
+		}
+		ext := path.Ext(reserved)
+		stem := strings.TrimSuffix(reserved, ext)
+		if stem == base || (strings.HasPrefix(stem, base+"_") && isDigits(stem[len(base)+1:])) {
+			return strings.TrimPrefix(ext, "."), true
+		}
+	}
+	return "", false
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// init parses the file name template and loads the manifest; the caller
+// must hold d.mu
+func (d *Downloader) init() error {
+	if d.template != nil {
+		return nil
+	}
+
+	source := d.Filename
+	if source == "" {
+		source = DefaultFilenameTemplate
+	}
+	t, err := ParseFilenameTemplate(source)
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]manifestEntry)
+	data, err := os.ReadFile(filepath.Join(d.Dir, ManifestName))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading download manifest: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &used); err != nil {
+			return fmt.Errorf("parsing download manifest: %w", err)
+		}
+	}
+
+	d.template = t
+	d.used = used
+	return nil
+}
+
+// available reports whether name can be used for a file of the photo with
+// guid: no file exists with that name, or its XMP sidecar names the photo,
+// as written by earlier downloads without a manifest. The caller must hold
+// d.mu.
+func (d *Downloader) available(name, guid string) bool {
+	target := filepath.Join(d.Dir, filepath.FromSlash(name))
+	if _, err := os.Lstat(target); err != nil {
+		return os.IsNotExist(err)
+	}
+
+	sidecar, err := os.ReadFile(xmp.SidecarPath(target))
+	if err != nil {
+		return false
+	}
+	var identifier bytes.Buffer
+	xml.EscapeText(&identifier, []byte(guid))
+	return bytes.Contains(sidecar, []byte(`dc:identifier="`+identifier.String()+`"`))
+}
+
+// current reports whether the file name was downloaded for the derivative
+// with checksum. Files without a recorded checksum are assumed current.
+func (d *Downloader) current(name, checksum string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	recorded := d.used[name].Checksum
+	return recorded == "" || checksum == "" || recorded == checksum
+}
+
+// recordChecksum notes the checksum of the derivative downloaded to name
+func (d *Downloader) recordChecksum(name, checksum string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if entry, ok := d.used[name]; ok && entry.Checksum != checksum {
+		entry.Checksum = checksum
+		d.used[name] = entry
+		d.dirty = true
+	}
+}
+
+// saveManifest writes the owners of the files in Dir to the manifest if
+// they changed. The file is replaced atomically.
+func (d *Downloader) saveManifest() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(d.used, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding download manifest: %w", err)
+	}
+
+	if err := os.MkdirAll(d.Dir, 0o755); err != nil {
+		return fmt.Errorf("creating download directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(d.Dir, ".manifest-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(d.Dir, ManifestName)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	d.dirty = false
+	return nil
+}
+
+// xmpMetadata returns the shared album metadata of photo written to
+// sidecars and embedded into downloaded files
+func xmpMetadata(photo Image, album string) xmp.Metadata {
+	return xmp.Metadata{
+		Caption:     photo.Caption,
+		Creator:     photo.ContributorFullName,
+		DateCreated: photo.DateCreated,
+		Identifier:  photo.PhotoGUID,
+		Source:      album,
+	}
+}
+
+// OpenURL requests a derivative URL through the client's rate limiter,
+// retries and trace hooks, adding header to the request, e.g. to ask for a
+// Range. Unlike the other methods it returns any response iCloud sends, so
+// check the status. The caller must close the response body.
+func (c *Client) OpenURL(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	resp, err := c.do(req, EndpointDownload)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// tempDownload is a file downloaded to a temporary location
+type tempDownload struct {
+	path        string
+	contentType string
+	// header holds the first bytes of the file for type detection
+	header []byte
+}
+
+// downloadTemp fetches rawURL into a temporary file in dir. The caller is
+// responsible for moving or removing the file.
+func (c *Client) downloadTemp(ctx context.Context, rawURL, dir string) (*tempDownload, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := c.do(req, EndpointDownload)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return nil, err
+	}
+
+	download := &tempDownload{
+		path:        tmp.Name(),
+		contentType: resp.Header.Get("Content-Type"),
+	}
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(resp.Body, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	download.header = header[:n]
+
+	if _, err := tmp.Write(download.header); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return download, nil
+}
@@ -0,0 +1,166 @@
+package icloudalbum
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	jpegData = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00 jpeg")
+	pngData  = []byte("\x89PNG\r\n\x1a\n png")
+)
+
+// newDownloadServer serves JPEG data at /*.jpg, except for PNG data at
+// /png.jpg
+func newDownloadServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/png.jpg" {
+			w.Write(pngData)
+			return
+		}
+		w.Write(jpegData)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func downloadPhoto(server *httptest.Server, guid, urlPath, checksum string) Image {
+	url := server.URL + urlPath
+	return Image{
+		PhotoGUID: guid,
+		Derivatives: map[string]Derivative{
+			"2048": {Checksum: checksum, URL: &url},
+		},
+	}
+}
+
+func TestDownloaderNames(t *testing.T) {
+	type download struct {
+		guid, urlPath, checksum string
+		wantPath                string
+		wantSkipped             bool
+	}
+
+	tests := []struct {
+		name string
+		// files exist in the directory before downloading
+		files map[string]string
+		// runs are downloads by separate Downloaders, one after another
+		runs      [][]download
+		wantFiles map[string]string
+	}{
+		{
+			name: "same name in one run",
+			runs: [][]download{{
+				{guid: "A", urlPath: "/a.jpg", checksum: "1", wantPath: "photo.jpg"},
+				{guid: "B", urlPath: "/b.jpg", checksum: "2", wantPath: "photo_2.jpg"},
+			}},
+		},
+		{
+			name:  "foreign file is not overwritten",
+			files: map[string]string{"photo.jpg": "other"},
+			runs: [][]download{{
+				{guid: "A", urlPath: "/a.jpg", checksum: "1", wantPath: "photo_2.jpg"},
+			}},
+			wantFiles: map[string]string{"photo.jpg": "other", "photo_2.jpg": string(jpegData)},
+		},
+		{
+			name:  "foreign file is not overwritten after renaming",
+			files: map[string]string{"photo.png": "other"},
+			runs: [][]download{{
+				{guid: "A", urlPath: "/png.jpg", checksum: "1", wantPath: "photo_2.png"},
+			}},
+			wantFiles: map[string]string{"photo.png": "other", "photo_2.png": string(pngData)},
+		},
+		{
+			name: "own files are skipped in later runs",
+			runs: [][]download{
+				{
+					{guid: "A", urlPath: "/a.jpg", checksum: "1", wantPath: "photo.jpg"},
+					{guid: "B", urlPath: "/png.jpg", checksum: "2", wantPath: "photo.png"},
+				},
+				{
+					{guid: "B", urlPath: "/png.jpg", checksum: "2", wantPath: "photo.png", wantSkipped: true},
+					{guid: "A", urlPath: "/a.jpg", checksum: "1", wantPath: "photo.jpg", wantSkipped: true},
+				},
+			},
+		},
+		{
+			name: "names stay reserved in later runs",
+			runs: [][]download{
+				{{guid: "A", urlPath: "/a.jpg", checksum: "1", wantPath: "photo.jpg"}},
+				{{guid: "B", urlPath: "/b.jpg", checksum: "2", wantPath: "photo_2.jpg"}},
+			},
+		},
+		{
+			name: "changed derivatives are downloaded again",
+			runs: [][]download{
+				{{guid: "A", urlPath: "/a.jpg", checksum: "1", wantPath: "photo.jpg"}},
+				{{guid: "A", urlPath: "/a.jpg", checksum: "2", wantPath: "photo.jpg"}},
+			},
+		},
+		{
+			name: "files with a sidecar naming the photo are claimed",
+			files: map[string]string{
+				"photo.jpg":     "earlier",
+				"photo.jpg.xmp": `<rdf:Description dc:identifier="A">`,
+			},
+			runs: [][]download{{
+				{guid: "A", urlPath: "/a.jpg", checksum: "1", wantPath: "photo.jpg", wantSkipped: true},
+			}},
+			wantFiles: map[string]string{"photo.jpg": "earlier"},
+		},
+		{
+			name: "files with a sidecar naming another photo are not",
+			files: map[string]string{
+				"photo.jpg":     "earlier",
+				"photo.jpg.xmp": `<rdf:Description dc:identifier="B">`,
+			},
+			runs: [][]download{{
+				{guid: "A", urlPath: "/a.jpg", checksum: "1", wantPath: "photo_2.jpg"},
+			}},
+			wantFiles: map[string]string{"photo.jpg": "earlier"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newDownloadServer(t)
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i, run := range tt.runs {
+				downloader := &Downloader{Client: NewClient(WithDebugOutput(nil)), Dir: dir, Filename: "photo.{ext}"}
+				for _, want := range run {
+					photo := downloadPhoto(server, want.guid, want.urlPath, want.checksum)
+					file, err := downloader.DownloadDerivative(context.Background(), photo, "2048")
+					if err != nil {
+						t.Fatalf("run %d: DownloadDerivative(%s) error = %v", i, want.guid, err)
+					}
+					if file.Path != want.wantPath || file.Skipped != want.wantSkipped {
+						t.Errorf("run %d: DownloadDerivative(%s) = %s skipped %v, want %s skipped %v",
+							i, want.guid, file.Path, file.Skipped, want.wantPath, want.wantSkipped)
+					}
+				}
+			}
+
+			for name, want := range tt.wantFiles {
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package icloudalbum
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/Shogoki/icloud-shared-album-go/exif"
+)
+
+// exifFetchSize is the number of bytes fetched by FetchEXIF. The EXIF
+// segment sits at the beginning of a JPEG and is limited to 64 KiB, but may
+// be preceded by other metadata segments.
+const exifFetchSize = 256 * 1024
+
+// exifConcurrency is the number of EXIF headers EnrichEXIF fetches at once
+const exifConcurrency = 4
+
+// defaultEXIFCacheSize is the number of photos whose EXIF metadata a Client
+// keeps in memory unless configured with WithEXIFCache
+const defaultEXIFCacheSize = 4096
+
+// WithEXIFCache keeps the EXIF metadata of up to size photos in memory,
+// keyed by derivative checksum, so FetchEXIF and EnrichEXIF only fetch it
+// once. A non-positive size disables the cache.
+func WithEXIFCache(size int) Option {
+	return func(c *Client) {
+		c.exifCache = newEXIFCache(size)
+	}
+}
+
+// ReadEXIF decodes the EXIF metadata of the downloaded file at path.
+// It returns nil without an error if the file has no EXIF metadata.
+func ReadEXIF(path string) (*EXIF, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return decodeEXIF(f)
+}
+
+// FetchEXIF fetches the beginning of the largest derivative of photo and
+// decodes its EXIF metadata. It returns nil without an error for videos and
+// files without EXIF metadata. Results are cached by the checksum of the
+// derivative and shared between callers, so they must not be modified.
+func (c *Client) FetchEXIF(ctx context.Context, photo Image) (*EXIF, error) {
+	if isVideo(photo) {
+		return nil, nil
+	}
+
+	_, derivative, ok := LargestDerivative(photo)
+	if !ok {
+		return nil, fmt.Errorf("photo %s has no derivatives", photo.PhotoGUID)
+	}
+	if data, ok := c.exifCache.get(derivative.Checksum); ok {
+		return data, nil
+	}
+	if derivative.URL == nil {
+		return nil, fmt.Errorf("photo %s has no derivative URL", photo.PhotoGUID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *derivative.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", exifFetchSize-1))
+
+	resp, err := c.do(req, EndpointDownload)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	data, err := decodeEXIF(io.LimitReader(resp.Body, exifFetchSize))
+	if err != nil {
+		return nil, err
+	}
+	c.exifCache.put(derivative.Checksum, data)
+	return data, nil
+}
+
+// EnrichEXIF sets Image.EXIF for every photo using FetchEXIF, fetching the
+// metadata of several photos at once. Photos whose metadata cannot be
+// fetched are left without it: their errors are returned joined once all
+// other photos are enriched. Only the cancellation of ctx stops early.
+func (c *Client) EnrichEXIF(ctx context.Context, photos []Image) error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		errs  []error
+		slots = make(chan struct{}, exifConcurrency)
+	)
+
+photos:
+	for i := range photos {
+		if isVideo(photos[i]) {
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			break photos
+		}
+
+		wg.Add(1)
+		go func(photo *Image) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			data, err := c.FetchEXIF(ctx, *photo)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("fetching EXIF for %s: %w", photo.PhotoGUID, err))
+				mu.Unlock()
+				return
+			}
+			photo.EXIF = data
+		}(&photos[i])
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// exifCache is a size bounded LRU cache of EXIF metadata by checksum. A nil
+// cache stores nothing.
+type exifCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type exifCacheEntry struct {
+	checksum string
+	data     *EXIF
+}
+
+func newEXIFCache(size int) *exifCache {
+	if size <= 0 {
+		return nil
+	}
+	return &exifCache{size: size, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// get returns the cached metadata for checksum, which is nil for photos
+// without EXIF metadata
+func (c *exifCache) get(checksum string) (*EXIF, bool) {
+	if c == nil || checksum == "" {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[checksum]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*exifCacheEntry).data, true
+}
+
+func (c *exifCache) put(checksum string, data *EXIF) {
+	if c == nil || checksum == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[checksum]; ok {
+		elem.Value.(*exifCacheEntry).data = data
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[checksum] = c.lru.PushFront(&exifCacheEntry{checksum: checksum, data: data})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*exifCacheEntry).checksum)
+	}
+}
+
+func decodeEXIF(r io.Reader) (*EXIF, error) {
+	data, err := exif.Decode(r)
+	if errors.Is(err, exif.ErrNoEXIF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EXIF{
+		Make:         data.Make,
+		Model:        data.Model,
+		LensMake:     data.LensMake,
+		LensModel:    data.LensModel,
+		ExposureTime: data.ExposureTime,
+		FNumber:      data.FNumber,
+		ISO:          data.ISO,
+		FocalLength:  data.FocalLength,
+		Orientation:  data.Orientation,
+	}
+	if !data.CaptureTime.IsZero() {
+		result.CaptureTime = &data.CaptureTime
+	}
+	if data.GPS != nil {
+		result.GPS = &Location{
+			Latitude:  data.GPS.Latitude,
+			Longitude: data.GPS.Longitude,
+			Altitude:  data.GPS.Altitude,
+		}
+	}
+
+	return result, nil
+}
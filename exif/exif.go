@@ -0,0 +1,406 @@
+// Package exif is a small, pure Go reader for the EXIF metadata embedded in
+// JPEG and TIFF files. It only decodes the tags describing when, where and
+// with what a photo was taken.
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrNoEXIF is returned when a file does not contain EXIF metadata
+var ErrNoEXIF = errors.New("exif: no EXIF data found")
+
+// Data holds the decoded EXIF tags. Fields are left at their zero value
+// when the corresponding tag is missing.
+type Data struct {
+	Make        string
+	Model       string
+	LensMake    string
+	LensModel   string
+	Software    string
+	Orientation int
+
+	// CaptureTime is DateTimeOriginal, falling back to DateTime. It is in
+	// the offset recorded in OffsetTimeOriginal, or UTC if there is none.
+	CaptureTime time.Time
+
+	// ExposureTime in seconds
+	ExposureTime float64
+	FNumber      float64
+	ISO          int
+	// FocalLength in millimetres
+	FocalLength float64
+
+	GPS *GPS
+}
+
+// GPS holds the decoded GPS position
+type GPS struct {
+	Latitude  float64
+	Longitude float64
+	// Altitude in metres above sea level, nil if not recorded
+	Altitude *float64
+}
+
+// Tags decoded from the IFDs
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagSoftware         = 0x0131
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagOffsetOriginal   = 0x9011
+	tagFocalLength      = 0x920A
+	tagLensMake         = 0xA433
+	tagLensModel        = 0xA434
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// TIFF field types
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+var typeSizes = map[uint16]int{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeUndefined: 1,
+	typeSLong:     4,
+	typeSRational: 8,
+}
+
+// Decode reads the EXIF metadata from a JPEG or TIFF file. Only the
+// beginning of the file up to the EXIF segment is read.
+func Decode(r io.Reader) (*Data, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, ErrNoEXIF
+	}
+
+	switch {
+	case magic[0] == 0xFF && magic[1] == 0xD8:
+		payload, err := findJPEGExif(br)
+		if err != nil {
+			return nil, err
+		}
+		return DecodeTIFF(payload)
+	case string(magic) == "II*\x00" || string(magic) == "MM\x00*":
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		return DecodeTIFF(data)
+	default:
+		return nil, ErrNoEXIF
+	}
+}
+
+// findJPEGExif returns the TIFF payload of the EXIF APP1 segment
+func findJPEGExif(r *bufio.Reader) ([]byte, error) {
+	// Skip SOI
+	if _, err := r.Discard(2); err != nil {
+		return nil, err
+	}
+
+	for {
+		marker, err := readMarker(r)
+		if err != nil {
+			return nil, ErrNoEXIF
+		}
+
+		// Start of scan or end of image: no more metadata segments
+		if marker == 0xDA || marker == 0xD9 {
+			return nil, ErrNoEXIF
+		}
+		// Markers without a length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			continue
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, ErrNoEXIF
+		}
+		if length < 2 {
+			return nil, fmt.Errorf("exif: invalid JPEG segment length %d", length)
+		}
+
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, ErrNoEXIF
+		}
+
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+func readMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("exif: invalid JPEG marker")
+	}
+	// Markers may be padded with any number of 0xFF bytes
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// entry is a single IFD entry
+type entry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// DecodeTIFF decodes EXIF metadata from a TIFF structure, as found in the
+// EXIF APP1 segment of a JPEG after the "Exif\0\0" header.
+func DecodeTIFF(data []byte) (*Data, error) {
+	if len(data) < 8 {
+		return nil, ErrNoEXIF
+	}
+
+	t := &tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("exif: invalid TIFF byte order")
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil, fmt.Errorf("exif: invalid TIFF header")
+	}
+
+	ifd0, err := t.readIFD(t.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Data{
+		Make:        t.string(ifd0[tagMake]),
+		Model:       t.string(ifd0[tagModel]),
+		Software:    t.string(ifd0[tagSoftware]),
+		Orientation: int(t.uint(ifd0[tagOrientation])),
+	}
+	dateTime := t.string(ifd0[tagDateTime])
+
+	if e, ok := ifd0[tagExifIFD]; ok {
+		exifIFD, err := t.readIFD(uint32(t.uint(e)))
+		if err != nil {
+			return nil, err
+		}
+
+		d.ExposureTime = t.rational(exifIFD[tagExposureTime], 0)
+		d.FNumber = t.rational(exifIFD[tagFNumber], 0)
+		d.ISO = int(t.uint(exifIFD[tagISO]))
+		d.FocalLength = t.rational(exifIFD[tagFocalLength], 0)
+		d.LensMake = t.string(exifIFD[tagLensMake])
+		d.LensModel = t.string(exifIFD[tagLensModel])
+
+		if original := t.string(exifIFD[tagDateTimeOriginal]); original != "" {
+			dateTime = original
+		}
+		d.CaptureTime = parseDateTime(dateTime, t.string(exifIFD[tagOffsetOriginal]))
+	} else {
+		d.CaptureTime = parseDateTime(dateTime, "")
+	}
+
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		gpsIFD, err := t.readIFD(uint32(t.uint(e)))
+		if err != nil {
+			return nil, err
+		}
+		d.GPS = t.gps(gpsIFD)
+	}
+
+	return d, nil
+}
+
+// readIFD reads the entries of the IFD at offset, keyed by tag
+func (t *tiff) readIFD(offset uint32) (map[uint16]entry, error) {
+	if int(offset)+2 > len(t.data) {
+		return nil, fmt.Errorf("exif: IFD offset out of range")
+	}
+
+	count := int(t.order.Uint16(t.data[offset:]))
+	entries := make(map[uint16]entry, count)
+	pos := int(offset) + 2
+
+	for i := 0; i < count; i++ {
+		if pos+12 > len(t.data) {
+			return nil, fmt.Errorf("exif: truncated IFD")
+		}
+		raw := t.data[pos : pos+12]
+		pos += 12
+
+		tag := t.order.Uint16(raw[0:])
+		typ := t.order.Uint16(raw[2:])
+		n := t.order.Uint32(raw[4:])
+
+		size, ok := typeSizes[typ]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(n)
+
+		var value []byte
+		if total <= 4 {
+			value = raw[8 : 8+total]
+		} else {
+			valueOffset := uint64(t.order.Uint32(raw[8:]))
+			if valueOffset+total > uint64(len(t.data)) {
+				continue
+			}
+			value = t.data[valueOffset : valueOffset+total]
+		}
+
+		entries[tag] = entry{typ: typ, count: n, value: value}
+	}
+
+	return entries, nil
+}
+
+func (t *tiff) string(e entry) string {
+	if e.typ != typeASCII && e.typ != typeUndefined {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// uint returns the first value of a BYTE, SHORT or LONG entry
+func (t *tiff) uint(e entry) uint64 {
+	switch {
+	case e.typ == typeByte && len(e.value) >= 1:
+		return uint64(e.value[0])
+	case e.typ == typeShort && len(e.value) >= 2:
+		return uint64(t.order.Uint16(e.value))
+	case (e.typ == typeLong || e.typ == typeSLong) && len(e.value) >= 4:
+		return uint64(t.order.Uint32(e.value))
+	}
+	return 0
+}
+
+// rational returns the i-th value of a RATIONAL or SRATIONAL entry
+func (t *tiff) rational(e entry, i int) float64 {
+	if (e.typ != typeRational && e.typ != typeSRational) || len(e.value) < (i+1)*8 {
+		return 0
+	}
+
+	raw := e.value[i*8:]
+	if e.typ == typeSRational {
+		num, den := int32(t.order.Uint32(raw)), int32(t.order.Uint32(raw[4:]))
+		if den == 0 {
+			return 0
+		}
+		return float64(num) / float64(den)
+	}
+
+	num, den := t.order.Uint32(raw), t.order.Uint32(raw[4:])
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+func (t *tiff) gps(ifd map[uint16]entry) *GPS {
+	lat, latOK := ifd[tagGPSLatitude]
+	lon, lonOK := ifd[tagGPSLongitude]
+	if !latOK || !lonOK {
+		return nil
+	}
+
+	g := &GPS{
+		Latitude:  t.degrees(lat),
+		Longitude: t.degrees(lon),
+	}
+	if t.string(ifd[tagGPSLatitudeRef]) == "S" {
+		g.Latitude = -g.Latitude
+	}
+	if t.string(ifd[tagGPSLongitudeRef]) == "W" {
+		g.Longitude = -g.Longitude
+	}
+
+	if alt, ok := ifd[tagGPSAltitude]; ok {
+		altitude := t.rational(alt, 0)
+		if ref, ok := ifd[tagGPSAltitudeRef]; ok && t.uint(ref) == 1 {
+			altitude = -altitude
+		}
+		g.Altitude = &altitude
+	}
+
+	if math.IsNaN(g.Latitude) || math.IsNaN(g.Longitude) {
+		return nil
+	}
+	return g
+}
+
+// degrees converts a degrees, minutes, seconds triple to decimal degrees
+func (t *tiff) degrees(e entry) float64 {
+	return t.rational(e, 0) + t.rational(e, 1)/60 + t.rational(e, 2)/3600
+}
+
+// parseDateTime parses an EXIF "2006:01:02 15:04:05" date with an optional
+// "+01:00" offset.
+func parseDateTime(value, offset string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return t
+		}
+	}
+
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
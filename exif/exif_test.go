@@ -0,0 +1,350 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// field is an IFD entry written by buildTIFF
+type field struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func ascii(tag uint16, s string) field {
+	return field{tag, typeASCII, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func short(order binary.ByteOrder, tag uint16, v uint16) field {
+	b := make([]byte, 2)
+	order.PutUint16(b, v)
+	return field{tag, typeShort, 1, b}
+}
+
+func long(order binary.ByteOrder, tag uint16, v uint32) field {
+	b := make([]byte, 4)
+	order.PutUint32(b, v)
+	return field{tag, typeLong, 1, b}
+}
+
+func rationals(order binary.ByteOrder, tag uint16, typ uint16, values ...uint32) field {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		order.PutUint32(b[i*4:], v)
+	}
+	return field{tag, typ, uint32(len(values) / 2), b}
+}
+
+// buildTIFF lays out a TIFF structure with IFD0 and optional EXIF and GPS
+// IFDs, adding the pointers to them to IFD0
+func buildTIFF(order binary.ByteOrder, ifd0, exifIFD, gpsIFD []field) []byte {
+	data := make([]byte, 8)
+	if order == binary.ByteOrder(binary.LittleEndian) {
+		copy(data, "II")
+	} else {
+		copy(data, "MM")
+	}
+	order.PutUint16(data[2:], 42)
+
+	writeIFD := func(fields []field) uint32 {
+		offset := uint32(len(data))
+		valuesAt := offset + 2 + uint32(len(fields))*12 + 4
+		var values []byte
+
+		ifd := make([]byte, 2, 2+len(fields)*12+4)
+		order.PutUint16(ifd, uint16(len(fields)))
+		for _, f := range fields {
+			raw := make([]byte, 12)
+			order.PutUint16(raw[0:], f.tag)
+			order.PutUint16(raw[2:], f.typ)
+			order.PutUint32(raw[4:], f.count)
+			if len(f.value) <= 4 {
+				copy(raw[8:], f.value)
+			} else {
+				order.PutUint32(raw[8:], valuesAt+uint32(len(values)))
+				values = append(values, f.value...)
+			}
+			ifd = append(ifd, raw...)
+		}
+		ifd = append(ifd, 0, 0, 0, 0)
+
+		data = append(data, ifd...)
+		data = append(data, values...)
+		return offset
+	}
+
+	if exifIFD != nil {
+		ifd0 = append(ifd0, long(order, tagExifIFD, writeIFD(exifIFD)))
+	}
+	if gpsIFD != nil {
+		ifd0 = append(ifd0, long(order, tagGPSIFD, writeIFD(gpsIFD)))
+	}
+	offset := writeIFD(ifd0)
+	order.PutUint32(data[4:], offset)
+	return data
+}
+
+func fullTIFF(order binary.ByteOrder) []byte {
+	return buildTIFF(order,
+		[]field{
+			ascii(tagMake, "Apple"),
+			ascii(tagModel, "iPhone 15 Pro"),
+			ascii(tagSoftware, "17.1"),
+			short(order, tagOrientation, 6),
+			ascii(tagDateTime, "2024:05:01 10:00:00"),
+		},
+		[]field{
+			rationals(order, tagExposureTime, typeRational, 1, 250),
+			rationals(order, tagFNumber, typeRational, 178, 100),
+			short(order, tagISO, 64),
+			rationals(order, tagFocalLength, typeRational, 6765, 1000),
+			ascii(tagLensMake, "Apple"),
+			ascii(tagLensModel, "iPhone 15 Pro back camera"),
+			ascii(tagDateTimeOriginal, "2024:05:01 09:30:15"),
+			ascii(tagOffsetOriginal, "+02:00"),
+		},
+		[]field{
+			ascii(tagGPSLatitudeRef, "S"),
+			rationals(order, tagGPSLatitude, typeRational, 33, 1, 51, 1, 54, 1),
+			ascii(tagGPSLongitudeRef, "W"),
+			rationals(order, tagGPSLongitude, typeRational, 70, 1, 30, 1, 0, 1),
+			{tagGPSAltitudeRef, typeByte, 1, []byte{1}},
+			rationals(order, tagGPSAltitude, typeRational, 125, 10),
+		},
+	)
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestDecodeTIFF(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	full := &Data{
+		Make:         "Apple",
+		Model:        "iPhone 15 Pro",
+		LensMake:     "Apple",
+		LensModel:    "iPhone 15 Pro back camera",
+		Software:     "17.1",
+		Orientation:  6,
+		CaptureTime:  time.Date(2024, 5, 1, 9, 30, 15, 0, time.FixedZone("", 2*60*60)),
+		ExposureTime: 0.004,
+		FNumber:      1.78,
+		ISO:          64,
+		FocalLength:  6.765,
+		GPS: &GPS{
+			Latitude:  -(33 + 51.0/60 + 54.0/3600),
+			Longitude: -70.5,
+			Altitude:  float(-12.5),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *Data
+		wantErr error
+	}{
+		{name: "little endian", data: fullTIFF(le), want: full},
+		{name: "big endian", data: fullTIFF(be), want: full},
+		{
+			name: "date time without EXIF IFD",
+			data: buildTIFF(le, []field{ascii(tagDateTime, "2023:12:24 18:00:00")}, nil, nil),
+			want: &Data{CaptureTime: time.Date(2023, 12, 24, 18, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "invalid offset falls back to UTC",
+			data: buildTIFF(le, nil, []field{
+				ascii(tagDateTimeOriginal, "2023:12:24 18:00:00"),
+				ascii(tagOffsetOriginal, "bogus"),
+			}, nil),
+			want: &Data{CaptureTime: time.Date(2023, 12, 24, 18, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "GPS without longitude",
+			data: buildTIFF(le, nil, nil, []field{
+				ascii(tagGPSLatitudeRef, "N"),
+				rationals(le, tagGPSLatitude, typeRational, 10, 1, 0, 1, 0, 1),
+			}),
+			want: &Data{},
+		},
+		{
+			name: "GPS without altitude",
+			data: buildTIFF(le, nil, nil, []field{
+				rationals(le, tagGPSLatitude, typeRational, 10, 1, 30, 1, 0, 1),
+				rationals(le, tagGPSLongitude, typeRational, 20, 1, 0, 1, 36, 1),
+			}),
+			want: &Data{GPS: &GPS{Latitude: 10.5, Longitude: 20.01}},
+		},
+		{
+			name: "zero denominator",
+			data: buildTIFF(le, nil, []field{rationals(le, tagFNumber, typeRational, 18, 0)}, nil),
+			want: &Data{},
+		},
+		{
+			name: "signed rational",
+			data: buildTIFF(le, nil, []field{rationals(le, tagExposureTime, typeSRational, 1, 60)}, nil),
+			want: &Data{ExposureTime: 1.0 / 60},
+		},
+		{
+			name: "value offset out of range is skipped",
+			data: func() []byte {
+				data := buildTIFF(le, []field{ascii(tagMake, "Canon"), ascii(tagModel, "EOS R5")}, nil, nil)
+				// Point the Make value past the end of the data
+				le.PutUint32(data[8+2+8:], 1<<20)
+				return data
+			}(),
+			want: &Data{Model: "EOS R5"},
+		},
+		{
+			name: "unknown type is skipped",
+			data: buildTIFF(le, []field{{tagMake, 99, 4, []byte("Sony")}, short(le, tagOrientation, 3)}, nil, nil),
+			want: &Data{Orientation: 3},
+		},
+		{
+			name: "wrong type is ignored",
+			data: buildTIFF(le, []field{short(le, tagMake, 1), ascii(tagOrientation, "6")}, nil, nil),
+			want: &Data{},
+		},
+		{name: "too short", data: []byte("II*\x00"), wantErr: ErrNoEXIF},
+		{name: "invalid byte order", data: []byte("XX*\x00\x08\x00\x00\x00\x00\x00"), wantErr: errAny},
+		{name: "invalid magic", data: []byte("II+\x00\x08\x00\x00\x00\x00\x00"), wantErr: errAny},
+		{name: "IFD offset out of range", data: []byte("II*\x00\xff\x00\x00\x00"), wantErr: errAny},
+		{
+			name:    "truncated IFD",
+			data:    append([]byte("II*\x00\x08\x00\x00\x00"), 5, 0, 0x0f, 0x01),
+			wantErr: errAny,
+		},
+		{
+			name:    "EXIF IFD out of range",
+			data:    buildTIFF(le, []field{long(le, tagExifIFD, 1<<20)}, nil, nil),
+			wantErr: errAny,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeTIFF(tt.data)
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatalf("DecodeTIFF() = %+v, want error", got)
+				}
+				if tt.wantErr != errAny && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DecodeTIFF() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeTIFF() error = %v", err)
+			}
+			assertData(t, got, tt.want)
+		})
+	}
+}
+
+// errAny matches any error in table tests
+var errAny = errors.New("any error")
+
+func assertData(t *testing.T, got, want *Data) {
+	t.Helper()
+
+	if !got.CaptureTime.Equal(want.CaptureTime) {
+		t.Errorf("CaptureTime = %v, want %v", got.CaptureTime, want.CaptureTime)
+	}
+	_, gotOffset := got.CaptureTime.Zone()
+	_, wantOffset := want.CaptureTime.Zone()
+	if gotOffset != wantOffset {
+		t.Errorf("CaptureTime offset = %d, want %d", gotOffset, wantOffset)
+	}
+
+	g, w := *got, *want
+	g.CaptureTime, w.CaptureTime = time.Time{}, time.Time{}
+	if g.GPS != nil && w.GPS != nil {
+		gps, wantGPS := *g.GPS, *w.GPS
+		if !approx(gps.Latitude, wantGPS.Latitude) || !approx(gps.Longitude, wantGPS.Longitude) {
+			t.Errorf("GPS = %v, %v, want %v, %v", gps.Latitude, gps.Longitude, wantGPS.Latitude, wantGPS.Longitude)
+		}
+		if (gps.Altitude == nil) != (wantGPS.Altitude == nil) || (gps.Altitude != nil && !approx(*gps.Altitude, *wantGPS.Altitude)) {
+			t.Errorf("GPS.Altitude = %v, want %v", gps.Altitude, wantGPS.Altitude)
+		}
+		g.GPS, w.GPS = nil, nil
+	}
+	g.ExposureTime, w.ExposureTime = round(g.ExposureTime), round(w.ExposureTime)
+	g.FNumber, w.FNumber = round(g.FNumber), round(w.FNumber)
+	g.FocalLength, w.FocalLength = round(g.FocalLength), round(w.FocalLength)
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("DecodeTIFF() = %+v, want %+v", g, w)
+	}
+}
+
+func approx(a, b float64) bool {
+	d := a - b
+	return d < 1e-9 && d > -1e-9
+}
+
+func round(v float64) float64 {
+	return float64(int64(v*1e9+0.5)) / 1e9
+}
+
+func TestDecode(t *testing.T) {
+	tiff := fullTIFF(binary.BigEndian)
+
+	segment := func(marker byte, payload []byte) []byte {
+		b := []byte{0xFF, marker, 0, 0}
+		binary.BigEndian.PutUint16(b[2:], uint16(len(payload)+2))
+		return append(b, payload...)
+	}
+	jpeg := func(segments ...[]byte) []byte {
+		b := []byte{0xFF, 0xD8}
+		for _, s := range segments {
+			b = append(b, s...)
+		}
+		return append(b, 0xFF, 0xDA, 0, 2)
+	}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		wantModel string
+		wantErr   error
+	}{
+		{
+			name:      "JPEG with EXIF after JFIF",
+			data:      jpeg(segment(0xE0, []byte("JFIF\x00\x01\x01")), segment(0xE1, append([]byte("Exif\x00\x00"), tiff...))),
+			wantModel: "iPhone 15 Pro",
+		},
+		{
+			name:      "JPEG with XMP before EXIF",
+			data:      jpeg(segment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>")), segment(0xE1, append([]byte("Exif\x00\x00"), tiff...))),
+			wantModel: "iPhone 15 Pro",
+		},
+		{name: "JPEG without EXIF", data: jpeg(segment(0xE0, []byte("JFIF\x00"))), wantErr: ErrNoEXIF},
+		{name: "TIFF", data: tiff, wantModel: "iPhone 15 Pro"},
+		{name: "PNG", data: []byte("\x89PNG\r\n\x1a\n"), wantErr: ErrNoEXIF},
+		{name: "empty", data: nil, wantErr: ErrNoEXIF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(bytes.NewReader(tt.data))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got.Model != tt.wantModel {
+				t.Errorf("Model = %q, want %q", got.Model, tt.wantModel)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package icloudalbum
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exporter writes an album Response in a specific format
+type Exporter interface {
+	// ContentType returns the MIME type of the exported data
+	ContentType() string
+
+	// Export writes resp to w
+	Export(w io.Writer, resp *Response) error
+}
+
+// NewExporter returns the built-in exporter for format, which is one of
+// "ndjson", "csv", "jsonfeed" or "geojson".
+func NewExporter(format string) (Exporter, error) {
+	switch strings.ToLower(format) {
+	case "ndjson":
+		return NDJSONExporter{}, nil
+	case "csv":
+		return CSVExporter{}, nil
+	case "jsonfeed":
+		return JSONFeedExporter{}, nil
+	case "geojson":
+		return GeoJSONExporter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// NDJSONExporter writes one JSON encoded Image per line
+type NDJSONExporter struct{}
+
+// ContentType implements Exporter
+func (NDJSONExporter) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// Export implements Exporter
+func (NDJSONExporter) Export(w io.Writer, resp *Response) error {
+	enc := json.NewEncoder(w)
+	for _, photo := range resp.Photos {
+		if err := enc.Encode(photo); err != nil {
+			return fmt.Errorf("encoding photo %s: %w", photo.PhotoGUID, err)
+		}
+	}
+	return nil
+}
+
+// CSVExporter writes one row per photo, flattened with a single derivative
+type CSVExporter struct {
+	// Derivative chooses the derivative written for each photo.
+	// Defaults to LargestDerivative.
+	Derivative DerivativeSelector
+}
+
+var csvHeader = []string{
+	"photoGuid",
+	"batchGuid",
+	"dateCreated",
+	"batchDateCreated",
+	"caption",
+	"contributorFullName",
+	"contributorFirstName",
+	"contributorLastName",
+	"width",
+	"height",
+	"mediaAssetType",
+	"derivative",
+	"derivativeWidth",
+	"derivativeHeight",
+	"fileSize",
+	"checksum",
+	"url",
+}
+
+// ContentType implements Exporter
+func (CSVExporter) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+// Export implements Exporter
+func (e CSVExporter) Export(w io.Writer, resp *Response) error {
+	selectDerivative := e.Derivative
+	if selectDerivative == nil {
+		selectDerivative = LargestDerivative
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, photo := range resp.Photos {
+		mediaAssetType := ""
+		if photo.MediaAssetType != nil {
+			mediaAssetType = *photo.MediaAssetType
+		}
+
+		record := []string{
+			photo.PhotoGUID,
+			photo.BatchGUID,
+			formatTime(photo.DateCreated),
+			formatTime(photo.BatchDateCreated),
+			photo.Caption,
+			photo.ContributorFullName,
+			photo.ContributorFirstName,
+			photo.ContributorLastName,
+			strconv.Itoa(photo.Width),
+			strconv.Itoa(photo.Height),
+			mediaAssetType,
+		}
+
+		if key, derivative, ok := selectDerivative(photo); ok {
+			url := ""
+			if derivative.URL != nil {
+				url = *derivative.URL
+			}
+			record = append(record,
+				key,
+				strconv.Itoa(derivative.Width),
+				strconv.Itoa(derivative.Height),
+				strconv.FormatInt(derivative.FileSize, 10),
+				derivative.Checksum,
+				url,
+			)
+		} else {
+			record = append(record, "", "", "", "", "", "")
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// JSONFeedExporter writes the album as a JSON Feed 1.1 document
+// (https://www.jsonfeed.org/version/1.1/) with one item per photo.
+type JSONFeedExporter struct {
+	// HomePageURL and FeedURL are optional feed level URLs
+	HomePageURL string
+	FeedURL     string
+
+	// Image chooses the derivative used as the item image.
+	// Defaults to LargestDerivative.
+	Image DerivativeSelector
+}
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url,omitempty"`
+	FeedURL     string           `json:"feed_url,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url,omitempty"`
+	ContentText   string               `json:"content_text"`
+	Image         string               `json:"image,omitempty"`
+	DatePublished string               `json:"date_published,omitempty"`
+	Authors       []jsonFeedAuthor     `json:"authors,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeedAttachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+// ContentType implements Exporter
+func (JSONFeedExporter) ContentType() string {
+	return "application/feed+json"
+}
+
+// Export implements Exporter
+func (e JSONFeedExporter) Export(w io.Writer, resp *Response) error {
+	selectImage := e.Image
+	if selectImage == nil {
+		selectImage = LargestDerivative
+	}
+
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       resp.Metadata.StreamName,
+		HomePageURL: e.HomePageURL,
+		FeedURL:     e.FeedURL,
+		Items:       make([]jsonFeedItem, 0, len(resp.Photos)),
+	}
+	if owner := strings.TrimSpace(resp.Metadata.UserFirstName + " " + resp.Metadata.UserLastName); owner != "" {
+		feed.Authors = []jsonFeedAuthor{{Name: owner}}
+	}
+
+	for _, photo := range resp.Photos {
+		item := jsonFeedItem{
+			ID:            photo.PhotoGUID,
+			ContentText:   photo.Caption,
+			DatePublished: formatTime(photo.DateCreated),
+		}
+		if photo.ContributorFullName != "" {
+			item.Authors = []jsonFeedAuthor{{Name: photo.ContributorFullName}}
+		}
+
+		if _, derivative, ok := selectImage(photo); ok && derivative.URL != nil {
+			item.URL = *derivative.URL
+			if isVideo(photo) {
+				item.Attachments = []jsonFeedAttachment{{
+					URL:         *derivative.URL,
+					MimeType:    "video/mp4",
+					SizeInBytes: derivative.FileSize,
+				}}
+			} else {
+				item.Image = *derivative.URL
+			}
+		}
+
+		feed.Items = append(feed.Items, item)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(feed)
+}
+
+// isVideo reports whether photo is a video asset
+func isVideo(photo Image) bool {
+	return photo.MediaAssetType != nil && *photo.MediaAssetType == "video"
+}
+
+// formatTime formats t as RFC 3339, or returns an empty string for the zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,257 @@
+package icloudalbum
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// exportAlbum has a photo with two derivatives, a video and a photo
+// without derivatives
+func exportAlbum() *Response {
+	created := time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)
+	return &Response{
+		Metadata: Metadata{StreamName: "Summer, 2024", UserFirstName: "Ada", UserLastName: "Lovelace"},
+		Photos: []Image{
+			{
+				PhotoGUID:           "P1",
+				BatchGUID:           "B1",
+				DateCreated:         created,
+				BatchDateCreated:    created,
+				Caption:             "Beach \"day\", 1",
+				ContributorFullName: "Ada Lovelace",
+				Width:               4032,
+				Height:              3024,
+				MediaAssetType:      stringPtr("image"),
+				Derivatives: map[string]Derivative{
+					"342":  {Checksum: "small", FileSize: 20_000, Width: 342, Height: 256, URL: stringPtr("https://example.com/p1-small.jpg")},
+					"2048": {Checksum: "large", FileSize: 900_000, Width: 2048, Height: 1536, URL: stringPtr("https://example.com/p1-large.jpg")},
+				},
+			},
+			{
+				PhotoGUID:      "V1",
+				MediaAssetType: stringPtr("video"),
+				Derivatives: map[string]Derivative{
+					"720p": {Checksum: "video", FileSize: 5_000_000, Width: 1280, Height: 720, URL: stringPtr("https://example.com/v1.mp4")},
+				},
+			},
+			{PhotoGUID: "E1"},
+		},
+	}
+}
+
+func TestNewExporter(t *testing.T) {
+	tests := []struct {
+		format      string
+		contentType string
+		wantErr     bool
+	}{
+		{"ndjson", "application/x-ndjson", false},
+		{"CSV", "text/csv; charset=utf-8", false},
+		{"jsonfeed", "application/feed+json", false},
+		{"geojson", "application/geo+json", false},
+		{"xml", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			exporter, err := NewExporter(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewExporter(%q) error = %v, wantErr %v", tt.format, err, tt.wantErr)
+			}
+			if err == nil && exporter.ContentType() != tt.contentType {
+				t.Errorf("ContentType() = %q, want %q", exporter.ContentType(), tt.contentType)
+			}
+		})
+	}
+}
+
+func TestNDJSONExporter(t *testing.T) {
+	tests := []struct {
+		name     string
+		resp     *Response
+		wantGUID []string
+	}{
+		{"album", exportAlbum(), []string{"P1", "V1", "E1"}},
+		{"empty", &Response{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := (NDJSONExporter{}).Export(&buf, tt.resp); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+
+			var guids []string
+			for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+				if line == "" {
+					continue
+				}
+				var photo Image
+				if err := json.Unmarshal([]byte(line), &photo); err != nil {
+					t.Fatalf("line %q is not a JSON image: %v", line, err)
+				}
+				guids = append(guids, photo.PhotoGUID)
+			}
+			if strings.Join(guids, ",") != strings.Join(tt.wantGUID, ",") {
+				t.Errorf("Export() GUIDs = %v, want %v", guids, tt.wantGUID)
+			}
+		})
+	}
+}
+
+func TestCSVExporter(t *testing.T) {
+	tests := []struct {
+		name     string
+		exporter CSVExporter
+		// want maps photo GUIDs to expected columns
+		want map[string]map[string]string
+	}{
+		{
+			name:     "largest derivative",
+			exporter: CSVExporter{},
+			want: map[string]map[string]string{
+				"P1": {
+					"dateCreated":    "2024-06-01T12:30:00Z",
+					"caption":        "Beach \"day\", 1",
+					"width":          "4032",
+					"mediaAssetType": "image",
+					"derivative":     "2048",
+					"fileSize":       "900000",
+					"checksum":       "large",
+					"url":            "https://example.com/p1-large.jpg",
+				},
+				"V1": {"mediaAssetType": "video", "derivative": "720p", "url": "https://example.com/v1.mp4"},
+				"E1": {"dateCreated": "", "mediaAssetType": "", "derivative": "", "url": ""},
+			},
+		},
+		{
+			name:     "smallest derivative",
+			exporter: CSVExporter{Derivative: SmallestDerivative},
+			want: map[string]map[string]string{
+				"P1": {"derivative": "342", "derivativeWidth": "342", "derivativeHeight": "256", "checksum": "small"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.exporter.Export(&buf, exportAlbum()); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+
+			records, err := csv.NewReader(&buf).ReadAll()
+			if err != nil {
+				t.Fatalf("invalid CSV: %v", err)
+			}
+			if len(records) != 4 {
+				t.Fatalf("got %d records, want header and 3 rows", len(records))
+			}
+			header := records[0]
+			if strings.Join(header, ",") != strings.Join(csvHeader, ",") {
+				t.Errorf("header = %v, want %v", header, csvHeader)
+			}
+
+			for _, record := range records[1:] {
+				if len(record) != len(header) {
+					t.Fatalf("row %v has %d columns, want %d", record, len(record), len(header))
+				}
+				row := make(map[string]string, len(header))
+				for i, column := range header {
+					row[column] = record[i]
+				}
+				for column, want := range tt.want[row["photoGuid"]] {
+					if row[column] != want {
+						t.Errorf("%s %s = %q, want %q", row["photoGuid"], column, row[column], want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestJSONFeedExporter(t *testing.T) {
+	tests := []struct {
+		name     string
+		exporter JSONFeedExporter
+		resp     *Response
+		check    func(t *testing.T, feed jsonFeed)
+	}{
+		{
+			name:     "album",
+			exporter: JSONFeedExporter{HomePageURL: "https://example.com/", FeedURL: "https://example.com/feed.json"},
+			resp:     exportAlbum(),
+			check: func(t *testing.T, feed jsonFeed) {
+				if feed.Version != "https://jsonfeed.org/version/1.1" || feed.Title != "Summer, 2024" {
+					t.Errorf("feed = %q %q", feed.Version, feed.Title)
+				}
+				if feed.HomePageURL != "https://example.com/" || feed.FeedURL != "https://example.com/feed.json" {
+					t.Errorf("feed URLs = %q %q", feed.HomePageURL, feed.FeedURL)
+				}
+				if len(feed.Authors) != 1 || feed.Authors[0].Name != "Ada Lovelace" {
+					t.Errorf("authors = %v", feed.Authors)
+				}
+				if len(feed.Items) != 3 {
+					t.Fatalf("got %d items, want 3", len(feed.Items))
+				}
+
+				photo, video, empty := feed.Items[0], feed.Items[1], feed.Items[2]
+				if photo.ID != "P1" || photo.Image != "https://example.com/p1-large.jpg" || photo.URL != photo.Image ||
+					photo.ContentText != "Beach \"day\", 1" || photo.DatePublished != "2024-06-01T12:30:00Z" ||
+					len(photo.Attachments) != 0 || len(photo.Authors) != 1 {
+					t.Errorf("photo item = %+v", photo)
+				}
+				if video.Image != "" || len(video.Attachments) != 1 || video.Attachments[0].MimeType != "video/mp4" ||
+					video.Attachments[0].SizeInBytes != 5_000_000 || len(video.Authors) != 0 {
+					t.Errorf("video item = %+v", video)
+				}
+				if empty.ID != "E1" || empty.URL != "" || empty.Image != "" || empty.DatePublished != "" {
+					t.Errorf("empty item = %+v", empty)
+				}
+			},
+		},
+		{
+			name:     "smallest image",
+			exporter: JSONFeedExporter{Image: SmallestDerivative},
+			resp:     exportAlbum(),
+			check: func(t *testing.T, feed jsonFeed) {
+				if feed.Items[0].Image != "https://example.com/p1-small.jpg" {
+					t.Errorf("image = %q", feed.Items[0].Image)
+				}
+			},
+		},
+		{
+			name: "empty album",
+			resp: &Response{},
+			check: func(t *testing.T, feed jsonFeed) {
+				if feed.Items == nil || len(feed.Items) != 0 || feed.Authors != nil {
+					t.Errorf("feed = %+v, want no items and authors", feed)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.exporter.Export(&buf, tt.resp); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+			var feed jsonFeed
+			if err := json.Unmarshal(buf.Bytes(), &feed); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			tt.check(t, feed)
+		})
+	}
+}
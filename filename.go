@@ -0,0 +1,328 @@
+package icloudalbum
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultFilenameTemplate is the file name template used by Downloader
+// when none is configured
+const DefaultFilenameTemplate = "{guid}_{derivative}.{ext}"
+
+// maxFieldLength limits the length in bytes of a single substituted field,
+// so long captions do not exceed file name limits
+const maxFieldLength = 64
+
+// FilenameTemplate names downloaded files. Templates are plain text with
+// placeholders in braces:
+//
+//	{guid}          photo GUID
+//	{batch}         batch GUID
+//	{derivative}    derivative key, e.g. "2049"
+//	{checksum}      derivative checksum
+//	{ext}           file extension without the dot
+//	{date}          creation date, optionally with a Go time layout
+//	                such as {date:2006-01-02_150405}
+//	{contributor}   contributor full name
+//	{caption}       photo caption
+//	{album}         album name
+//	{width}         derivative width
+//	{height}        derivative height
+//
+// Substituted values are sanitised to be safe on all common file systems.
+// Literal slashes in the template create subdirectories, e.g.
+// "{date:2006}/{date:01}/{guid}.{ext}".
+type FilenameTemplate struct {
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal string
+	field   string
+	arg     string
+}
+
+// FilenameData is the data a FilenameTemplate is executed with
+type FilenameData struct {
+	Photo         Image
+	DerivativeKey string
+	Derivative    Derivative
+	Album         string
+	// Ext is the file extension without the dot
+	Ext string
+}
+
+var filenameFields = map[string]bool{
+	"guid":        true,
+	"batch":       true,
+	"derivative":  true,
+	"checksum":    true,
+	"ext":         true,
+	"date":        true,
+	"contributor": true,
+	"caption":     true,
+	"album":       true,
+	"width":       true,
+	"height":      true,
+}
+
+// ParseFilenameTemplate parses a file name template, see FilenameTemplate
+func ParseFilenameTemplate(s string) (*FilenameTemplate, error) {
+	t := &FilenameTemplate{}
+	for len(s) > 0 {
+		open := strings.IndexByte(s, '{')
+		if open < 0 {
+			t.parts = append(t.parts, templatePart{literal: s})
+			break
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: s[:open]})
+		}
+
+		end := strings.IndexByte(s[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("filename template: unclosed placeholder in %q", s)
+		}
+		placeholder := s[open+1 : open+end]
+		s = s[open+end+1:]
+
+		field, arg, _ := strings.Cut(placeholder, ":")
+		if !filenameFields[field] {
+			return nil, fmt.Errorf("filename template: unknown placeholder {%s}", field)
+		}
+		if arg != "" && field != "date" {
+			return nil, fmt.Errorf("filename template: {%s} does not take an argument", field)
+		}
+		t.parts = append(t.parts, templatePart{field: field, arg: arg})
+	}
+
+	if len(t.parts) == 0 {
+		return nil, fmt.Errorf("filename template is empty")
+	}
+	for _, dir := range strings.Split(t.String(), "/") {
+		if dir == ".." {
+			return nil, fmt.Errorf("filename template: must not contain ..")
+		}
+	}
+	return t, nil
+}
+
+// String returns the template source
+func (t *FilenameTemplate) String() string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.field == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		b.WriteString("{" + part.field)
+		if part.arg != "" {
+			b.WriteString(":" + part.arg)
+		}
+		b.WriteString("}")
+	}
+	return b.String()
+}
+
+// Execute returns the slash separated relative path for data
+func (t *FilenameTemplate) Execute(data FilenameData) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.field == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		value := sanitizeElement(fieldValue(part, data), maxFieldLength)
+		if value == "" {
+			// Drop the separator in front of an empty field
+			trimmed := strings.TrimRight(b.String(), "_- ")
+			b.Reset()
+			b.WriteString(trimmed)
+		}
+		b.WriteString(value)
+	}
+
+	// Clean every path element of the result, e.g. to avoid empty or
+	// reserved names produced by empty fields
+	elements := strings.Split(b.String(), "/")
+	cleaned := elements[:0]
+	for _, element := range elements {
+		if element = SanitizeFilename(element, 255); element != "" {
+			cleaned = append(cleaned, element)
+		}
+	}
+	return strings.Join(cleaned, "/")
+}
+
+func fieldValue(part templatePart, data FilenameData) string {
+	switch part.field {
+	case "guid":
+		return data.Photo.PhotoGUID
+	case "batch":
+		return data.Photo.BatchGUID
+	case "derivative":
+		return data.DerivativeKey
+	case "checksum":
+		return data.Derivative.Checksum
+	case "ext":
+		return data.Ext
+	case "date":
+		if data.Photo.DateCreated.IsZero() {
+			return "undated"
+		}
+		layout := part.arg
+		if layout == "" {
+			layout = "2006-01-02"
+		}
+		return data.Photo.DateCreated.Format(layout)
+	case "contributor":
+		return data.Photo.ContributorFullName
+	case "caption":
+		return data.Photo.Caption
+	case "album":
+		return data.Album
+	case "width":
+		return strconv.Itoa(data.Derivative.Width)
+	case "height":
+		return strconv.Itoa(data.Derivative.Height)
+	}
+	return ""
+}
+
+// Names reserved on Windows regardless of extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename makes s safe to use as a single file name element on
+// common file systems: path separators, characters reserved on Windows and
+// control characters are replaced by underscores, runs of whitespace are
+// collapsed into one underscore, leading and trailing dots and spaces are
+// trimmed and the result is limited to maxLen bytes.
+func SanitizeFilename(s string, maxLen int) string {
+	result := sanitizeElement(s, maxLen)
+	base, _, _ := strings.Cut(result, ".")
+	if reservedNames[strings.ToUpper(base)] {
+		result = "_" + result
+	}
+	return result
+}
+
+func sanitizeElement(s string, maxLen int) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			if !space {
+				b.WriteByte('_')
+			}
+			space = true
+			continue
+		case r < 0x20 || r == 0x7F || strings.ContainsRune(`<>:"/\|?*`, r):
+			b.WriteByte('_')
+		case r == utf8.RuneError:
+			continue
+		default:
+			b.WriteRune(r)
+		}
+		space = false
+	}
+
+	result := strings.Trim(b.String(), ". _")
+	if len(result) > maxLen {
+		end := maxLen
+		for end > 0 && !utf8.RuneStart(result[end]) {
+			end--
+		}
+		result = strings.TrimRight(result[:end], ". _")
+	}
+	return result
+}
+
+// DetectExtension determines the file extension (without the dot) of a
+// downloaded file from its first bytes, falling back to the Content-Type
+// header and the extension in the URL path. It returns "bin" if the type
+// can't be determined.
+func DetectExtension(header []byte, contentType, rawURL string) string {
+	if ext := sniffExtension(header); ext != "" {
+		return ext
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if ext, ok := contentTypeExtensions[mediaType]; ok {
+			return ext
+		}
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			return strings.TrimPrefix(exts[0], ".")
+		}
+	}
+
+	if u, err := url.Parse(rawURL); err == nil {
+		if ext := strings.ToLower(strings.TrimPrefix(path.Ext(u.Path), ".")); ext != "" {
+			if ext == "jpeg" {
+				ext = "jpg"
+			}
+			return ext
+		}
+	}
+
+	return "bin"
+}
+
+// contentTypeExtensions holds the preferred extension for media types where
+// the mime package's choice is ambiguous or missing
+var contentTypeExtensions = map[string]string{
+	"image/jpeg":      "jpg",
+	"image/png":       "png",
+	"image/gif":       "gif",
+	"image/webp":      "webp",
+	"image/heic":      "heic",
+	"image/heif":      "heif",
+	"image/tiff":      "tif",
+	"video/mp4":       "mp4",
+	"video/quicktime": "mov",
+	"video/x-m4v":     "m4v",
+}
+
+// sniffExtension recognises the media formats found in shared albums by
+// their magic bytes
+func sniffExtension(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return "gif"
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return "webp"
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return "tif"
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		switch string(header[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis":
+			return "heic"
+		case "mif1", "msf1":
+			return "heif"
+		case "qt  ":
+			return "mov"
+		case "M4V ", "M4VH", "M4VP":
+			return "m4v"
+		default:
+			return "mp4"
+		}
+	}
+	return ""
+}
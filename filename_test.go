@@ -0,0 +1,163 @@
+package icloudalbum
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		maxLen int
+		want   string
+	}{
+		{"plain", "IMG_0001.jpg", 255, "IMG_0001.jpg"},
+		{"spaces", "Beach day", 255, "Beach_day"},
+		{"whitespace runs", "tab\there\n\nline", 255, "tab_here_line"},
+		{"separators", `a/b\c`, 255, "a_b_c"},
+		{"reserved characters", `what? <yes>`, 255, "what___yes"},
+		{"control characters", "\x00x\x7f", 255, "x"},
+		{"invalid UTF-8", "a\xffb", 255, "ab"},
+		{"leading and trailing dots", "  ..hidden.. ", 255, "hidden"},
+		{"trailing dot", "a.b. ", 255, "a.b"},
+		{"empty", "", 255, ""},
+		{"only dots", "...", 255, ""},
+		{"reserved name", "CON", 255, "_CON"},
+		{"reserved name with extension", "con.txt", 255, "_con.txt"},
+		{"reserved prefix", "CONSOLE", 255, "CONSOLE"},
+		{"reserved port", "COM1.jpg", 255, "_COM1.jpg"},
+		{"truncated", "abcdefghij", 5, "abcde"},
+		{"truncated at rune start", "ééé", 3, "é"},
+		{"truncated before dot", "abc.defgh", 4, "abc"},
+		{"unicode", "Überraschung 🎉", 255, "Überraschung_🎉"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeFilename(tt.in, tt.maxLen); got != tt.want {
+				t.Errorf("SanitizeFilename(%q, %d) = %q, want %q", tt.in, tt.maxLen, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilenameTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string
+	}{
+		{template: DefaultFilenameTemplate},
+		{template: "{date:2006-01-02_150405}_{guid}.{ext}"},
+		{template: "{date:2006}/{date:01}/{guid}.{ext}"},
+		{template: "photo.jpg"},
+		{template: "a..b/{guid}"},
+		{template: "", wantErr: "empty"},
+		{template: "{guid", wantErr: "unclosed"},
+		{template: "{foo}.{ext}", wantErr: "unknown placeholder {foo}"},
+		{template: "{}", wantErr: "unknown placeholder"},
+		{template: "{guid:x}", wantErr: "does not take an argument"},
+		{template: "../{guid}", wantErr: ".."},
+		{template: "{guid}/../x", wantErr: ".."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			got, err := ParseFilenameTemplate(tt.template)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseFilenameTemplate(%q) error = %v, want %q", tt.template, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFilenameTemplate(%q) error = %v", tt.template, err)
+			}
+			if got.String() != tt.template {
+				t.Errorf("String() = %q, want %q", got.String(), tt.template)
+			}
+		})
+	}
+}
+
+func TestFilenameTemplateExecute(t *testing.T) {
+	data := FilenameData{
+		Photo: Image{
+			PhotoGUID:           "G1",
+			BatchGUID:           "B1",
+			Caption:             "Sunset at the beach",
+			ContributorFullName: "Ada Lovelace",
+			DateCreated:         time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC),
+		},
+		DerivativeKey: "2048",
+		Derivative:    Derivative{Checksum: "abc", Width: 2048, Height: 1536},
+		Album:         "Summer/2024",
+		Ext:           "jpg",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		modify   func(*FilenameData)
+		want     string
+	}{
+		{name: "default", template: DefaultFilenameTemplate, want: "G1_2048.jpg"},
+		{name: "fields", template: "{date}_{contributor}_{guid}.{ext}", want: "2024-06-01_Ada_Lovelace_G1.jpg"},
+		{name: "subdirectories", template: "{date:2006}/{date:01}/{guid}.{ext}", want: "2024/06/G1.jpg"},
+		{name: "sanitised album", template: "{album}/{guid}", want: "Summer_2024/G1"},
+		{name: "size and checksum", template: "{batch}_{width}x{height}_{checksum}", want: "B1_2048x1536_abc"},
+		{
+			name:     "empty field drops separator",
+			template: "{guid}_{caption}.{ext}",
+			modify:   func(d *FilenameData) { d.Photo.Caption = "" },
+			want:     "G1.jpg",
+		},
+		{
+			name:     "empty directory is dropped",
+			template: "{caption}/{guid}",
+			modify:   func(d *FilenameData) { d.Photo.Caption = "" },
+			want:     "G1",
+		},
+		{
+			name:     "undated",
+			template: "{date}_{guid}",
+			modify:   func(d *FilenameData) { d.Photo.DateCreated = time.Time{} },
+			want:     "undated_G1",
+		},
+		{
+			name:     "long caption",
+			template: "{caption}",
+			modify:   func(d *FilenameData) { d.Photo.Caption = strings.Repeat("a", 100) },
+			want:     strings.Repeat("a", maxFieldLength),
+		},
+		{
+			name:     "reserved name",
+			template: "{caption}.{ext}",
+			modify:   func(d *FilenameData) { d.Photo.Caption = "con" },
+			want:     "_con.jpg",
+		},
+		{
+			name:     "path in caption",
+			template: "{caption}.{ext}",
+			modify:   func(d *FilenameData) { d.Photo.Caption = "../../etc/passwd" },
+			want:     "etc_passwd.jpg",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := ParseFilenameTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("ParseFilenameTemplate(%q) error = %v", tt.template, err)
+			}
+			d := data
+			if tt.modify != nil {
+				tt.modify(&d)
+			}
+			if got := template.Execute(d); got != tt.want {
+				t.Errorf("Execute() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
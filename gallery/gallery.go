@@ -0,0 +1,267 @@
+// Package gallery renders an iCloud shared album into a self-contained
+// static HTML gallery.
+package gallery
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+)
+
+//go:embed templates/*.html
+var defaultTemplates embed.FS
+
+const (
+	indexTemplate = "index.html"
+	photoTemplate = "photo.html"
+	photosDir     = "photos"
+	assetsDir     = "assets"
+)
+
+// Theme is a set of html/template templates used to render the gallery.
+// A theme must define the templates "index.html" and "photo.html".
+type Theme struct {
+	templates *template.Template
+}
+
+// DefaultTheme returns the built-in theme
+func DefaultTheme() *Theme {
+	return &Theme{
+		templates: template.Must(template.ParseFS(defaultTemplates, "templates/*.html")),
+	}
+}
+
+// ParseTheme returns the default theme with the templates matching
+// patterns in fsys parsed on top of it. Templates in fsys replace the
+// built-in templates with the same name, so a theme may override only
+// "style" or "photo.html" and keep everything else.
+func ParseTheme(fsys fs.FS, patterns ...string) (*Theme, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"*.html"}
+	}
+
+	t, err := DefaultTheme().templates.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := t.ParseFS(fsys, patterns...); err != nil {
+		return nil, fmt.Errorf("parsing theme: %w", err)
+	}
+
+	return &Theme{templates: t}, nil
+}
+
+// Options configures the generated gallery
+type Options struct {
+	// Title of the gallery. Defaults to the album name.
+	Title string
+
+	// Thumbnail chooses the derivative shown in the index.
+	// Defaults to icloudalbum.SmallestDerivative.
+	Thumbnail icloudalbum.DerivativeSelector
+
+	// Full chooses the derivative shown on photo pages.
+	// Defaults to icloudalbum.LargestDerivative.
+	Full icloudalbum.DerivativeSelector
+
+	// Download saves the chosen derivatives next to the pages instead of
+	// linking to the (expiring) iCloud URLs.
+	Download bool
+
+	// Client is used for downloads. Defaults to a new Client.
+	Client *icloudalbum.Client
+
+	// Theme renders the pages. Defaults to DefaultTheme.
+	Theme *Theme
+}
+
+// Photo is a single photo as seen by the templates. Links are relative to
+// the page being rendered.
+type Photo struct {
+	GUID        string
+	Caption     string
+	Contributor string
+	Date        time.Time
+	Width       int
+	Height      int
+	Video       bool
+	Thumbnail   string
+	Full        string
+	Page        string
+}
+
+// IndexData is passed to the "index.html" template
+type IndexData struct {
+	Title  string
+	Owner  string
+	Photos []Photo
+}
+
+// PhotoData is passed to the "photo.html" template
+type PhotoData struct {
+	Title string
+	Index string
+	Photo Photo
+	Prev  *Photo
+	Next  *Photo
+}
+
+// Generate renders resp into dir: an index.html with all photos and one
+// page per photo in dir/photos. With Options.Download the images are saved
+// to dir/assets.
+func Generate(ctx context.Context, resp *icloudalbum.Response, dir string, opts Options) error {
+	if opts.Title == "" {
+		opts.Title = resp.Metadata.StreamName
+	}
+	if opts.Thumbnail == nil {
+		opts.Thumbnail = icloudalbum.SmallestDerivative
+	}
+	if opts.Full == nil {
+		opts.Full = icloudalbum.LargestDerivative
+	}
+	if opts.Theme == nil {
+		opts.Theme = DefaultTheme()
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, photosDir), 0o755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+
+	downloader := &icloudalbum.Downloader{
+		Client: opts.Client,
+		Dir:    filepath.Join(dir, assetsDir),
+	}
+
+	// Links relative to the gallery root
+	photos := make([]Photo, 0, len(resp.Photos))
+	pages := make(map[string]bool, len(resp.Photos))
+	for _, image := range resp.Photos {
+		thumbnail, err := resolveAsset(ctx, downloader, opts.Download, image, opts.Thumbnail)
+		if err != nil {
+			return err
+		}
+		full, err := resolveAsset(ctx, downloader, opts.Download, image, opts.Full)
+		if err != nil {
+			return err
+		}
+
+		photos = append(photos, Photo{
+			GUID:        image.PhotoGUID,
+			Caption:     image.Caption,
+			Contributor: image.ContributorFullName,
+			Date:        image.DateCreated,
+			Width:       image.Width,
+			Height:      image.Height,
+			Video:       image.MediaAssetType != nil && *image.MediaAssetType == "video",
+			Thumbnail:   thumbnail,
+			Full:        full,
+			Page:        path.Join(photosDir, pageName(image.PhotoGUID, pages)),
+		})
+	}
+
+	index := IndexData{
+		Title:  opts.Title,
+		Owner:  strings.TrimSpace(resp.Metadata.UserFirstName + " " + resp.Metadata.UserLastName),
+		Photos: photos,
+	}
+	if err := render(opts.Theme, indexTemplate, filepath.Join(dir, "index.html"), index); err != nil {
+		return err
+	}
+
+	for i, photo := range photos {
+		data := PhotoData{
+			Title: opts.Title,
+			Index: "../index.html",
+			Photo: fromPhotosDir(photo),
+		}
+		if i > 0 {
+			prev := fromPhotosDir(photos[i-1])
+			data.Prev = &prev
+		}
+		if i < len(photos)-1 {
+			next := fromPhotosDir(photos[i+1])
+			data.Next = &next
+		}
+
+		target := filepath.Join(dir, photosDir, path.Base(photo.Page))
+		if err := render(opts.Theme, photoTemplate, target, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pageName returns the file name of the page of the photo with guid. GUIDs
+// come from iCloud, so they are sanitised like downloaded file names and
+// made unique among the names in used.
+func pageName(guid string, used map[string]bool) string {
+	base := icloudalbum.SanitizeFilename(guid, 200)
+	if base == "" {
+		base = "photo"
+	}
+
+	name := base + ".html"
+	for n := 2; used[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s_%d.html", base, n)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// resolveAsset returns the link to the selected derivative of image,
+// downloading it first if requested.
+func resolveAsset(ctx context.Context, d *icloudalbum.Downloader, download bool, image icloudalbum.Image, selectDerivative icloudalbum.DerivativeSelector) (string, error) {
+	key, derivative, ok := selectDerivative(image)
+	if !ok || derivative.URL == nil {
+		return "", nil
+	}
+	if !download {
+		return *derivative.URL, nil
+	}
+
+	file, err := d.DownloadDerivative(ctx, image, key)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(assetsDir, filepath.ToSlash(file.Path)), nil
+}
+
+// fromPhotosDir rewrites the root relative links of photo for a page
+// inside the photos directory.
+func fromPhotosDir(photo Photo) Photo {
+	photo.Page = path.Base(photo.Page)
+	photo.Thumbnail = fromSubdir(photo.Thumbnail)
+	photo.Full = fromSubdir(photo.Full)
+	return photo
+}
+
+func fromSubdir(link string) string {
+	if link == "" || strings.Contains(link, "://") {
+		return link
+	}
+	return "../" + link
+}
+
+func render(theme *Theme, name, target string, data any) error {
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	if err := theme.templates.ExecuteTemplate(f, name, data); err != nil {
+		f.Close()
+		return fmt.Errorf("rendering %s: %w", name, err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,154 @@
+package gallery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+)
+
+var jpegData = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00 jpeg")
+
+func galleryAlbum(server *httptest.Server) *icloudalbum.Response {
+	photo := func(guid string) icloudalbum.Image {
+		small, large := server.URL+"/"+guid+"-small", server.URL+"/"+guid+"-large"
+		return icloudalbum.Image{
+			PhotoGUID: guid,
+			Caption:   "Caption of " + guid,
+			Derivatives: map[string]icloudalbum.Derivative{
+				"342":  {Checksum: guid + "s", FileSize: 10, Width: 342, URL: &small},
+				"2048": {Checksum: guid + "l", FileSize: 100, Width: 2048, URL: &large},
+			},
+		}
+	}
+	return &icloudalbum.Response{
+		Metadata: icloudalbum.Metadata{StreamName: "Summer", UserFirstName: "Ada", UserLastName: "Lovelace"},
+		Photos:   []icloudalbum.Image{photo("P1"), photo("../x"), photo("a/b"), photo("a_b"), photo(""), photo("../../y")},
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name     string
+		download bool
+		// wantFiles are all files generated, relative to the output
+		// directory
+		wantFiles []string
+		// wantIndex are strings the index must contain
+		wantIndex []string
+	}{
+		{
+			name: "linked",
+			wantFiles: []string{
+				"index.html",
+				"photos/P1.html",
+				"photos/a_b.html",
+				"photos/a_b_2.html",
+				"photos/photo.html",
+				"photos/x.html",
+				"photos/y.html",
+			},
+			wantIndex: []string{
+				`<title>Summer</title>`,
+				`href="photos/P1.html"`,
+				`href="photos/x.html"`,
+				`href="photos/a_b_2.html"`,
+				`/P1-small`,
+			},
+		},
+		{
+			name:     "downloaded",
+			download: true,
+			wantFiles: []string{
+				"assets/" + icloudalbum.ManifestName,
+				"assets/2048.jpg",
+				"assets/342.jpg",
+				"assets/P1_2048.jpg",
+				"assets/P1_342.jpg",
+				"assets/a_b_2048.jpg",
+				"assets/a_b_2048_2.jpg",
+				"assets/a_b_342.jpg",
+				"assets/a_b_342_2.jpg",
+				"assets/x_2048.jpg",
+				"assets/x_342.jpg",
+				"assets/y_2048.jpg",
+				"assets/y_342.jpg",
+				"index.html",
+				"photos/P1.html",
+				"photos/a_b.html",
+				"photos/a_b_2.html",
+				"photos/photo.html",
+				"photos/x.html",
+				"photos/y.html",
+			},
+			wantIndex: []string{`src="assets/P1_342.jpg"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(jpegData)
+			}))
+			defer server.Close()
+
+			root := t.TempDir()
+			dir := filepath.Join(root, "gallery")
+			opts := Options{
+				Download: tt.download,
+				Client:   icloudalbum.NewClient(icloudalbum.WithDebugOutput(nil)),
+			}
+			if err := Generate(context.Background(), galleryAlbum(server), dir, opts); err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+
+			var files []string
+			err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(dir, path)
+				if err != nil {
+					return err
+				}
+				if strings.HasPrefix(rel, "..") {
+					t.Errorf("Generate() wrote %s outside the output directory", path)
+				}
+				files = append(files, filepath.ToSlash(rel))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(files)
+			sort.Strings(tt.wantFiles)
+			if strings.Join(files, "\n") != strings.Join(tt.wantFiles, "\n") {
+				t.Errorf("files = %q, want %q", files, tt.wantFiles)
+			}
+
+			index, err := os.ReadFile(filepath.Join(dir, "index.html"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.wantIndex {
+				if !strings.Contains(string(index), want) {
+					t.Errorf("index.html does not contain %s", want)
+				}
+			}
+
+			page, err := os.ReadFile(filepath.Join(dir, "photos", "x.html"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(page), `href="P1.html"`) || !strings.Contains(string(page), `href="a_b.html"`) {
+				t.Errorf("photos/x.html does not link to its neighbours:\n%s", page)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package icloudalbum
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// FeatureCollection is a GeoJSON FeatureCollection (RFC 7946)
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON Feature for a single geolocated photo
+type Feature struct {
+	Type       string            `json:"type"`
+	ID         string            `json:"id"`
+	Geometry   Point             `json:"geometry"`
+	Properties FeatureProperties `json:"properties"`
+}
+
+// Point is a GeoJSON Point geometry
+type Point struct {
+	Type string `json:"type"`
+	// Coordinates are longitude, latitude and optionally altitude
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// FeatureProperties describes the photo of a Feature
+type FeatureProperties struct {
+	Caption      string `json:"caption"`
+	DateCreated  string `json:"dateCreated,omitempty"`
+	Contributor  string `json:"contributor,omitempty"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+	AssetType    string `json:"assetType"`
+}
+
+// GeoJSON returns a FeatureCollection with one Feature per photo with a
+// known location. Locations are read from the EXIF metadata, so photos must
+// have been enriched with Client.EnrichEXIF or a Downloader first. The
+// thumbnail selector defaults to SmallestDerivative.
+func GeoJSON(resp *Response, thumbnail DerivativeSelector) FeatureCollection {
+	if thumbnail == nil {
+		thumbnail = SmallestDerivative
+	}
+
+	collection := FeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []Feature{},
+	}
+
+	for _, photo := range resp.Photos {
+		if photo.EXIF == nil || photo.EXIF.GPS == nil {
+			continue
+		}
+		gps := photo.EXIF.GPS
+
+		coordinates := []float64{gps.Longitude, gps.Latitude}
+		if gps.Altitude != nil {
+			coordinates = append(coordinates, *gps.Altitude)
+		}
+
+		properties := FeatureProperties{
+			Caption:     photo.Caption,
+			DateCreated: formatTime(photo.DateCreated),
+			Contributor: photo.ContributorFullName,
+			AssetType:   "image",
+		}
+		if isVideo(photo) {
+			properties.AssetType = "video"
+		}
+		if _, derivative, ok := thumbnail(photo); ok && derivative.URL != nil {
+			properties.ThumbnailURL = *derivative.URL
+		}
+
+		collection.Features = append(collection.Features, Feature{
+			Type:       "Feature",
+			ID:         photo.PhotoGUID,
+			Geometry:   Point{Type: "Point", Coordinates: coordinates},
+			Properties: properties,
+		})
+	}
+
+	return collection
+}
+
+// GeoJSONExporter writes the geolocated photos of an album as a GeoJSON
+// FeatureCollection, see GeoJSON
+type GeoJSONExporter struct {
+	// Thumbnail chooses the derivative linked from every feature.
+	// Defaults to SmallestDerivative.
+	Thumbnail DerivativeSelector
+}
+
+// ContentType implements Exporter
+func (GeoJSONExporter) ContentType() string {
+	return "application/geo+json"
+}
+
+// Export implements Exporter
+func (e GeoJSONExporter) Export(w io.Writer, resp *Response) error {
+	return json.NewEncoder(w).Encode(GeoJSON(resp, e.Thumbnail))
+}
@@ -0,0 +1,311 @@
+// Package hugo bakes iCloud shared albums into a Hugo site, either as data
+// files or as page bundles with downloaded images.
+package hugo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported data and front matter formats
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// Album is the data written for an album
+type Album struct {
+	Name   string    `json:"name" yaml:"name" toml:"name"`
+	Owner  string    `json:"owner,omitempty" yaml:"owner,omitempty" toml:"owner,omitempty"`
+	Ctag   string    `json:"ctag,omitempty" yaml:"ctag,omitempty" toml:"ctag,omitempty"`
+	Photos []Photo   `json:"photos" yaml:"photos" toml:"photos"`
+	Synced time.Time `json:"synced" yaml:"synced" toml:"synced"`
+}
+
+// Photo is the data written for a single photo
+type Photo struct {
+	GUID        string  `json:"guid" yaml:"guid" toml:"guid"`
+	Caption     string  `json:"caption,omitempty" yaml:"caption,omitempty" toml:"caption,omitempty"`
+	Date        string  `json:"date,omitempty" yaml:"date,omitempty" toml:"date,omitempty"`
+	Contributor string  `json:"contributor,omitempty" yaml:"contributor,omitempty" toml:"contributor,omitempty"`
+	Width       int     `json:"width" yaml:"width" toml:"width"`
+	Height      int     `json:"height" yaml:"height" toml:"height"`
+	Type        string  `json:"type" yaml:"type" toml:"type"`
+	Thumbnail   *Source `json:"thumbnail,omitempty" yaml:"thumbnail,omitempty" toml:"thumbnail,omitempty"`
+	Full        *Source `json:"full,omitempty" yaml:"full,omitempty" toml:"full,omitempty"`
+}
+
+// Source is a single derivative of a photo
+type Source struct {
+	URL    string `json:"url" yaml:"url" toml:"url"`
+	Width  int    `json:"width" yaml:"width" toml:"width"`
+	Height int    `json:"height" yaml:"height" toml:"height"`
+}
+
+// Options configures the generated files
+type Options struct {
+	// Format of data files and front matter: FormatJSON (default),
+	// FormatYAML or FormatTOML.
+	Format string
+
+	// Name of the data file or bundle directory, without extension.
+	// Defaults to a slug of the album name.
+	Name string
+
+	// Thumbnail and Full choose the derivatives written for each photo.
+	// They default to icloudalbum.SmallestDerivative and
+	// icloudalbum.LargestDerivative.
+	Thumbnail icloudalbum.DerivativeSelector
+	Full      icloudalbum.DerivativeSelector
+
+	// Client is used to download images for page bundles.
+	// Defaults to a new Client.
+	Client *icloudalbum.Client
+}
+
+func (o *Options) setDefaults(resp *icloudalbum.Response) error {
+	if o.Format == "" {
+		o.Format = FormatJSON
+	}
+	switch o.Format {
+	case FormatJSON, FormatYAML, FormatTOML:
+	default:
+		return fmt.Errorf("unsupported format %q", o.Format)
+	}
+	if o.Name == "" {
+		o.Name = Slugify(resp.Metadata.StreamName)
+	}
+	if o.Name == "" {
+		return fmt.Errorf("album has no name, set Options.Name")
+	}
+	if o.Thumbnail == nil {
+		o.Thumbnail = icloudalbum.SmallestDerivative
+	}
+	if o.Full == nil {
+		o.Full = icloudalbum.LargestDerivative
+	}
+	return nil
+}
+
+// NewAlbum converts resp to the album data written by WriteData
+func NewAlbum(resp *icloudalbum.Response, thumbnail, full icloudalbum.DerivativeSelector) Album {
+	album := Album{
+		Name:   resp.Metadata.StreamName,
+		Owner:  strings.TrimSpace(resp.Metadata.UserFirstName + " " + resp.Metadata.UserLastName),
+		Ctag:   resp.Metadata.StreamCtag,
+		Photos: make([]Photo, 0, len(resp.Photos)),
+		Synced: time.Now().UTC().Truncate(time.Second),
+	}
+
+	for _, image := range resp.Photos {
+		photo := newPhoto(image)
+		photo.Thumbnail = newSource(image, thumbnail)
+		photo.Full = newSource(image, full)
+		album.Photos = append(album.Photos, photo)
+	}
+
+	return album
+}
+
+func newPhoto(image icloudalbum.Image) Photo {
+	photo := Photo{
+		GUID:        image.PhotoGUID,
+		Caption:     image.Caption,
+		Contributor: image.ContributorFullName,
+		Width:       image.Width,
+		Height:      image.Height,
+		Type:        "image",
+	}
+	if !image.DateCreated.IsZero() {
+		photo.Date = image.DateCreated.Format(time.RFC3339)
+	}
+	if image.MediaAssetType != nil && *image.MediaAssetType == "video" {
+		photo.Type = "video"
+	}
+	return photo
+}
+
+func newSource(image icloudalbum.Image, selectDerivative icloudalbum.DerivativeSelector) *Source {
+	_, derivative, ok := selectDerivative(image)
+	if !ok || derivative.URL == nil {
+		return nil
+	}
+	return &Source{URL: *derivative.URL, Width: derivative.Width, Height: derivative.Height}
+}
+
+// WriteData writes resp as a Hugo data file to dataDir (e.g. "data/albums")
+// and returns its path. The album is then available in templates as
+// .Site.Data.albums.<name>.
+func WriteData(resp *icloudalbum.Response, dataDir string, opts Options) (string, error) {
+	if err := opts.setDefaults(resp); err != nil {
+		return "", err
+	}
+
+	data, err := marshal(opts.Format, NewAlbum(resp, opts.Thumbnail, opts.Full))
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return "", fmt.Errorf("creating data directory: %w", err)
+	}
+
+	target := filepath.Join(dataDir, opts.Name+"."+opts.Format)
+	if err := os.WriteFile(target, data, 0o644); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// resource is a page resource entry in the bundle front matter
+type resource struct {
+	Src    string         `json:"src" yaml:"src" toml:"src"`
+	Title  string         `json:"title,omitempty" yaml:"title,omitempty" toml:"title,omitempty"`
+	Params resourceParams `json:"params" yaml:"params" toml:"params"`
+}
+
+type resourceParams struct {
+	GUID        string `json:"guid" yaml:"guid" toml:"guid"`
+	Date        string `json:"date,omitempty" yaml:"date,omitempty" toml:"date,omitempty"`
+	Contributor string `json:"contributor,omitempty" yaml:"contributor,omitempty" toml:"contributor,omitempty"`
+	Width       int    `json:"width" yaml:"width" toml:"width"`
+	Height      int    `json:"height" yaml:"height" toml:"height"`
+	Type        string `json:"type" yaml:"type" toml:"type"`
+}
+
+type frontMatter struct {
+	Title     string     `json:"title" yaml:"title" toml:"title"`
+	Date      string     `json:"date,omitempty" yaml:"date,omitempty" toml:"date,omitempty"`
+	Owner     string     `json:"owner,omitempty" yaml:"owner,omitempty" toml:"owner,omitempty"`
+	Ctag      string     `json:"ctag,omitempty" yaml:"ctag,omitempty" toml:"ctag,omitempty"`
+	Resources []resource `json:"resources" yaml:"resources" toml:"resources"`
+}
+
+// WriteBundle writes resp as a Hugo leaf bundle to contentDir/<name>: an
+// index.md whose front matter lists every photo as a page resource with
+// its caption, date, contributor and dimensions, and the downloaded images
+// next to it. It returns the bundle directory.
+func WriteBundle(ctx context.Context, resp *icloudalbum.Response, contentDir string, opts Options) (string, error) {
+	if err := opts.setDefaults(resp); err != nil {
+		return "", err
+	}
+
+	bundleDir := filepath.Join(contentDir, opts.Name)
+	downloader := &icloudalbum.Downloader{
+		Client:     opts.Client,
+		Dir:        bundleDir,
+		Derivative: opts.Full,
+	}
+
+	files, err := downloader.Download(ctx, resp)
+	if err != nil {
+		return "", err
+	}
+
+	fm := frontMatter{
+		Title:     resp.Metadata.StreamName,
+		Owner:     strings.TrimSpace(resp.Metadata.UserFirstName + " " + resp.Metadata.UserLastName),
+		Ctag:      resp.Metadata.StreamCtag,
+		Resources: make([]resource, 0, len(files)),
+	}
+
+	var first time.Time
+	for _, file := range files {
+		photo := newPhoto(file.Photo)
+		fm.Resources = append(fm.Resources, resource{
+			Src:   filepath.ToSlash(file.Path),
+			Title: photo.Caption,
+			Params: resourceParams{
+				GUID:        photo.GUID,
+				Date:        photo.Date,
+				Contributor: photo.Contributor,
+				Width:       file.Derivative.Width,
+				Height:      file.Derivative.Height,
+				Type:        photo.Type,
+			},
+		})
+
+		if created := file.Photo.DateCreated; !created.IsZero() && (first.IsZero() || created.Before(first)) {
+			first = created
+		}
+	}
+	if !first.IsZero() {
+		fm.Date = first.Format(time.RFC3339)
+	}
+
+	content, err := marshalFrontMatter(opts.Format, fm)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(filepath.Join(bundleDir, "index.md"), content, 0o644); err != nil {
+		return "", err
+	}
+	return bundleDir, nil
+}
+
+func marshal(format string, v any) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		return yaml.Marshal(v)
+	case FormatTOML:
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+}
+
+// marshalFrontMatter encodes v as front matter with the delimiters Hugo
+// expects for format.
+func marshalFrontMatter(format string, v any) ([]byte, error) {
+	data, err := marshal(format, v)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatYAML:
+		return []byte("---\n" + string(data) + "---\n"), nil
+	case FormatTOML:
+		return []byte("+++\n" + string(data) + "+++\n"), nil
+	default:
+		return data, nil
+	}
+}
+
+// Slugify converts name into a lower-case, dash separated identifier
+// usable as a file name and Hugo data key.
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
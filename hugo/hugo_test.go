@@ -0,0 +1,242 @@
+package hugo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+	"gopkg.in/yaml.v3"
+)
+
+var jpegData = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00 jpeg")
+
+func hugoAlbum(server *httptest.Server) *icloudalbum.Response {
+	url := func(path string) *string {
+		u := server.URL + path
+		return &u
+	}
+	video := "video"
+	return &icloudalbum.Response{
+		Metadata: icloudalbum.Metadata{
+			StreamName:    "Summer in Berlin!",
+			UserFirstName: "Ada",
+			UserLastName:  "Lovelace",
+			StreamCtag:    "ctag",
+		},
+		Photos: []icloudalbum.Image{
+			{
+				PhotoGUID:           "P2",
+				Caption:             "Beach",
+				ContributorFullName: "Ada Lovelace",
+				DateCreated:         time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC),
+				Width:               4032,
+				Height:              3024,
+				Derivatives: map[string]icloudalbum.Derivative{
+					"342":  {Checksum: "s", FileSize: 10, Width: 342, Height: 256, URL: url("/p2-small.jpg")},
+					"2048": {Checksum: "l", FileSize: 100, Width: 2048, Height: 1536, URL: url("/p2-large.jpg")},
+				},
+			},
+			{
+				PhotoGUID:      "V1",
+				DateCreated:    time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC),
+				MediaAssetType: &video,
+				Derivatives: map[string]icloudalbum.Derivative{
+					"720p": {Checksum: "v", FileSize: 1000, Width: 1280, Height: 720, URL: url("/v1.jpg")},
+				},
+			},
+		},
+	}
+}
+
+func newJPEGServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jpegData)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func unmarshal(t *testing.T, format string, data []byte, v any) {
+	t.Helper()
+	var err error
+	switch format {
+	case FormatYAML:
+		err = yaml.Unmarshal(data, v)
+	case FormatTOML:
+		err = toml.Unmarshal(data, v)
+	default:
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		t.Fatalf("invalid %s: %v\n%s", format, err, data)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Summer in Berlin!", "summer-in-berlin"},
+		{"  --Trip 2024--  ", "trip-2024"},
+		{"Überraschung", "überraschung"},
+		{"a/b\\c", "a-b-c"},
+		{"!!!", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := Slugify(tt.in); got != tt.want {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteData(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		resp     func(*httptest.Server) *icloudalbum.Response
+		wantPath string
+		wantErr  string
+	}{
+		{name: "json", opts: Options{}, wantPath: "summer-in-berlin.json"},
+		{name: "yaml", opts: Options{Format: FormatYAML}, wantPath: "summer-in-berlin.yaml"},
+		{name: "toml", opts: Options{Format: FormatTOML, Name: "trip"}, wantPath: "trip.toml"},
+		{name: "unsupported format", opts: Options{Format: "xml"}, wantErr: "unsupported format"},
+		{
+			name:    "no name",
+			resp:    func(*httptest.Server) *icloudalbum.Response { return &icloudalbum.Response{} },
+			wantErr: "album has no name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newJPEGServer(t)
+			resp := hugoAlbum(server)
+			if tt.resp != nil {
+				resp = tt.resp(server)
+			}
+			dataDir := filepath.Join(t.TempDir(), "data", "albums")
+
+			path, err := WriteData(resp, dataDir, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("WriteData() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WriteData() error = %v", err)
+			}
+			if path != filepath.Join(dataDir, tt.wantPath) {
+				t.Errorf("WriteData() = %s, want %s", path, tt.wantPath)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var album Album
+			format := strings.TrimPrefix(filepath.Ext(path), ".")
+			unmarshal(t, format, data, &album)
+
+			if album.Name != "Summer in Berlin!" || album.Owner != "Ada Lovelace" || album.Ctag != "ctag" || album.Synced.IsZero() {
+				t.Errorf("album = %+v", album)
+			}
+			if len(album.Photos) != 2 {
+				t.Fatalf("got %d photos, want 2", len(album.Photos))
+			}
+			photo, video := album.Photos[0], album.Photos[1]
+			if photo.GUID != "P2" || photo.Caption != "Beach" || photo.Date != "2024-06-02T12:00:00Z" ||
+				photo.Contributor != "Ada Lovelace" || photo.Width != 4032 || photo.Type != "image" {
+				t.Errorf("photo = %+v", photo)
+			}
+			if photo.Thumbnail == nil || photo.Thumbnail.URL != server.URL+"/p2-small.jpg" || photo.Thumbnail.Width != 342 {
+				t.Errorf("thumbnail = %+v", photo.Thumbnail)
+			}
+			if photo.Full == nil || photo.Full.URL != server.URL+"/p2-large.jpg" || photo.Full.Height != 1536 {
+				t.Errorf("full = %+v", photo.Full)
+			}
+			if video.GUID != "V1" || video.Type != "video" || video.Caption != "" {
+				t.Errorf("video = %+v", video)
+			}
+		})
+	}
+}
+
+func TestWriteBundle(t *testing.T) {
+	tests := []struct {
+		format    string
+		delimiter string
+	}{
+		{FormatJSON, ""},
+		{FormatYAML, "---\n"},
+		{FormatTOML, "+++\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			server := newJPEGServer(t)
+			contentDir := filepath.Join(t.TempDir(), "content", "albums")
+			opts := Options{Format: tt.format, Client: icloudalbum.NewClient(icloudalbum.WithDebugOutput(nil))}
+
+			bundleDir, err := WriteBundle(context.Background(), hugoAlbum(server), contentDir, opts)
+			if err != nil {
+				t.Fatalf("WriteBundle() error = %v", err)
+			}
+			if bundleDir != filepath.Join(contentDir, "summer-in-berlin") {
+				t.Errorf("WriteBundle() = %s", bundleDir)
+			}
+
+			data, err := os.ReadFile(filepath.Join(bundleDir, "index.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			content := string(data)
+			if !strings.HasPrefix(content, tt.delimiter) || !strings.HasSuffix(content, tt.delimiter) {
+				t.Fatalf("index.md is not delimited by %q:\n%s", tt.delimiter, content)
+			}
+			content = strings.TrimSuffix(strings.TrimPrefix(content, tt.delimiter), tt.delimiter)
+
+			var fm frontMatter
+			unmarshal(t, tt.format, []byte(content), &fm)
+			if fm.Title != "Summer in Berlin!" || fm.Owner != "Ada Lovelace" || fm.Ctag != "ctag" ||
+				fm.Date != "2024-06-01T08:00:00Z" {
+				t.Errorf("front matter = %+v", fm)
+			}
+			if len(fm.Resources) != 2 {
+				t.Fatalf("got %d resources, want 2", len(fm.Resources))
+			}
+
+			wantParams := []resourceParams{
+				{GUID: "P2", Date: "2024-06-02T12:00:00Z", Contributor: "Ada Lovelace", Width: 2048, Height: 1536, Type: "image"},
+				{GUID: "V1", Date: "2024-06-01T08:00:00Z", Width: 1280, Height: 720, Type: "video"},
+			}
+			for i, resource := range fm.Resources {
+				if resource.Params != wantParams[i] {
+					t.Errorf("resource %d params = %+v, want %+v", i, resource.Params, wantParams[i])
+				}
+				image, err := os.ReadFile(filepath.Join(bundleDir, filepath.FromSlash(resource.Src)))
+				if err != nil {
+					t.Errorf("resource %d: %v", i, err)
+				} else if string(image) != string(jpegData) {
+					t.Errorf("resource %s has unexpected content", resource.Src)
+				}
+			}
+			if fm.Resources[0].Title != "Beach" || fm.Resources[1].Title != "" {
+				t.Errorf("resource titles = %q, %q", fm.Resources[0].Title, fm.Resources[1].Title)
+			}
+		})
+	}
+}
@@ -2,13 +2,17 @@ package icloudalbum
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
+	"os"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 const chunkSize = 25
@@ -16,66 +20,315 @@ const chunkSize = 25
 // Client represents an iCloud album client
 type Client struct {
 	httpClient *http.Client
+	limiter    *hostLimiter
+	trace      *ClientTrace
+
+	maxRetries   int
+	retryBackoff time.Duration
+
+	tracerProvider trace.TracerProvider
+
+	exifCache *exifCache
+
+	debugOutput io.Writer
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithRateLimit limits the requests sent to each iCloud host to
+// requestsPerSecond, allowing bursts of up to burst requests. The limit is
+// shared by all concurrent calls made through the same Client.
+// A non-positive requestsPerSecond disables rate limiting.
+func WithRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(c *Client) {
+		if requestsPerSecond <= 0 {
+			c.limiter = nil
+			return
+		}
+		c.limiter = newHostLimiter(requestsPerSecond, burst)
+	}
+}
+
+// WithTrace registers lifecycle hooks called for every request made by the
+// Client, see ClientTrace.
+func WithTrace(trace *ClientTrace) Option {
+	return func(c *Client) {
+		c.trace = trace
+	}
+}
+
+// WithRetry retries requests failing with a network error, 429 or 5xx
+// status up to maxRetries times, waiting backoff before the first retry and
+// doubling the delay for every following one.
+func WithRetry(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryBackoff = backoff
+	}
+}
+
+// WithDebugOutput sets where the Client writes its debug messages about
+// requests and responses, os.Stderr by default. A nil w discards them.
+func WithDebugOutput(w io.Writer) Option {
+	return func(c *Client) {
+		c.debugOutput = w
+	}
 }
 
 // NewClient creates a new iCloud album client
-func NewClient() *Client {
-	return &Client{
+func NewClient(opts ...Option) *Client {
+	c := &Client{
 		httpClient: &http.Client{
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				return http.ErrUseLastResponse
 			},
 		},
+		exifCache:   newEXIFCache(defaultEXIFCacheSize),
+		debugOutput: os.Stderr,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // GetImages retrieves images from an iCloud shared album
 func (c *Client) GetImages(token string) (*Response, error) {
-	baseURL := getBaseURL(token)
-	fmt.Printf("Initial baseURL: %s\n", baseURL)
-	
-	// Handle potential redirects (added in 2024)
-	redirectedBaseURL, err := c.getRedirectedBaseURL(baseURL, token)
+	return c.GetImagesContext(context.Background(), token)
+}
+
+// GetImagesContext is like GetImages but carries ctx through all requests
+// made to iCloud, for cancellation and trace propagation.
+func (c *Client) GetImagesContext(ctx context.Context, token string) (resp *Response, err error) {
+	ctx, span := c.startSpan(ctx, "GetImages", albumIDAttr(token))
+	defer func() { endSpan(span, err) }()
+
+	baseURL, err := c.discoverBaseURL(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	apiResponse, err := c.getAPIResponse(ctx, baseURL, "")
+	if err != nil {
+		return nil, fmt.Errorf("getting API response: %w", err)
+	}
+	c.debugf("Got API response with %d photos\n", len(apiResponse.PhotoGUIDs))
+
+	allURLs, err := c.resolveURLs(ctx, baseURL, apiResponse.PhotoGUIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	c.debugf("Total URLs collected: %d\n", len(allURLs))
+	enrichedPhotos := c.enrichImagesWithURLs(apiResponse, allURLs)
+	c.debugf("Enriched %d photos with URLs\n", len(enrichedPhotos))
+
+	span.SetAttributes(
+		attrPhotoCount.Int(len(enrichedPhotos)),
+		attrURLCount.Int(len(allURLs)),
+	)
+
+	return &Response{
+		Metadata: apiResponse.Metadata,
+		Photos:   enrichedPhotos,
+	}, nil
+}
+
+// GetAlbum retrieves the album metadata and photos without resolving any
+// derivative URLs. Use ResolveURLs to fetch URLs for the photos you need.
+func (c *Client) GetAlbum(token string) (*Response, error) {
+	return c.GetAlbumContext(context.Background(), token)
+}
+
+// GetAlbumContext is like GetAlbum but carries ctx through all requests made
+// to iCloud.
+func (c *Client) GetAlbumContext(ctx context.Context, token string) (resp *Response, err error) {
+	ctx, span := c.startSpan(ctx, "GetAlbum", albumIDAttr(token))
+	defer func() { endSpan(span, err) }()
+
+	baseURL, err := c.discoverBaseURL(ctx, token)
 	if err != nil {
-		return nil, fmt.Errorf("getting redirected base URL: %w", err)
+		return nil, err
 	}
-	fmt.Printf("Redirected baseURL: %s\n", redirectedBaseURL)
 
-	apiResponse, err := c.getAPIResponse(redirectedBaseURL)
+	apiResponse, err := c.getAPIResponse(ctx, baseURL, "")
 	if err != nil {
 		return nil, fmt.Errorf("getting API response: %w", err)
 	}
-	fmt.Printf("Got API response with %d photos\n", len(apiResponse.PhotoGUIDs))
+	c.debugf("Got API response with %d photos\n", len(apiResponse.PhotoGUIDs))
+
+	photos := orderedPhotos(apiResponse)
+	span.SetAttributes(attrPhotoCount.Int(len(photos)))
 
+	return &Response{
+		Metadata: apiResponse.Metadata,
+		Photos:   photos,
+	}, nil
+}
+
+// ErrNotModified is returned by GetAlbumIfChanged if the album did not
+// change since the given stream ctag.
+var ErrNotModified = errors.New("icloudalbum: album not modified")
+
+// GetAlbumIfChanged is like GetAlbum but returns ErrNotModified if the
+// album's stream ctag still equals ctag, which is cheaper than comparing
+// full responses. An empty ctag always fetches the album.
+func (c *Client) GetAlbumIfChanged(token, ctag string) (*Response, error) {
+	return c.GetAlbumIfChangedContext(context.Background(), token, ctag)
+}
+
+// GetAlbumIfChangedContext is like GetAlbumIfChanged but carries ctx
+// through all requests made to iCloud.
+func (c *Client) GetAlbumIfChangedContext(ctx context.Context, token, ctag string) (resp *Response, err error) {
+	ctx, span := c.startSpan(ctx, "GetAlbumIfChanged", albumIDAttr(token))
+	defer func() {
+		if errors.Is(err, ErrNotModified) {
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
+
+	baseURL, err := c.discoverBaseURL(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	apiResponse, err := c.getAPIResponse(ctx, baseURL, ctag)
+	if err != nil {
+		return nil, fmt.Errorf("getting API response: %w", err)
+	}
+	if ctag != "" {
+		if apiResponse.Metadata.StreamCtag == ctag {
+			return nil, ErrNotModified
+		}
+		// A request carrying a ctag is not guaranteed to list every
+		// photo, so fetch the full album once it is known to have changed
+		if apiResponse, err = c.getAPIResponse(ctx, baseURL, ""); err != nil {
+			return nil, fmt.Errorf("getting API response: %w", err)
+		}
+	}
+
+	photos := orderedPhotos(apiResponse)
+	span.SetAttributes(attrPhotoCount.Int(len(photos)))
+
+	return &Response{
+		Metadata: apiResponse.Metadata,
+		Photos:   photos,
+	}, nil
+}
+
+// orderedPhotos returns the photos of apiResponse in album order
+func orderedPhotos(apiResponse *APIResponse) []Image {
+	photos := make([]Image, 0, len(apiResponse.PhotoGUIDs))
+	for _, photoGUID := range apiResponse.PhotoGUIDs {
+		if photo, ok := apiResponse.Photos[photoGUID]; ok {
+			photos = append(photos, photo)
+		}
+	}
+	return photos
+}
+
+// ResolveURLs resolves the derivative URLs for the given photo GUIDs only.
+// The returned map is keyed by derivative checksum and can be applied to
+// photos returned by GetAlbum with ApplyURLs.
+func (c *Client) ResolveURLs(token string, photoGUIDs []string) (map[string]string, error) {
+	return c.ResolveURLsContext(context.Background(), token, photoGUIDs)
+}
+
+// ResolveURLsContext is like ResolveURLs but carries ctx through all
+// requests made to iCloud.
+func (c *Client) ResolveURLsContext(ctx context.Context, token string, photoGUIDs []string) (urls map[string]string, err error) {
+	ctx, span := c.startSpan(ctx, "ResolveURLs", albumIDAttr(token), attrPhotoCount.Int(len(photoGUIDs)))
+	defer func() { endSpan(span, err) }()
+
+	baseURL, err := c.discoverBaseURL(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	urls, err = c.resolveURLs(ctx, baseURL, photoGUIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(attrURLCount.Int(len(urls)))
+	return urls, nil
+}
+
+// ApplyURLs sets the URL of every derivative whose checksum is present in
+// urls. Derivatives without a matching URL are left untouched.
+func ApplyURLs(photos []Image, urls map[string]string) {
+	for _, photo := range photos {
+		for derivativeKey, derivative := range photo.Derivatives {
+			if url, ok := urls[derivative.Checksum]; ok {
+				derivative.URL = &url
+				photo.Derivatives[derivativeKey] = derivative
+			}
+		}
+	}
+}
+
+// discoverBaseURL returns the shared streams base URL for the token,
+// following the host redirect Apple introduced in 2024.
+func (c *Client) discoverBaseURL(ctx context.Context, token string) (_ string, err error) {
+	ctx, span := c.startSpan(ctx, "discover")
+	defer func() { endSpan(span, err) }()
+
+	baseURL := getBaseURL(token)
+	c.debugf("Initial baseURL: %s\n", baseURL)
+
+	redirectedBaseURL, err := c.getRedirectedBaseURL(ctx, baseURL, token)
+	if err != nil {
+		return "", fmt.Errorf("getting redirected base URL: %w", err)
+	}
+	c.debugf("Redirected baseURL: %s\n", redirectedBaseURL)
+
+	span.SetAttributes(attrHost.String(hostOf(redirectedBaseURL)))
+
+	return redirectedBaseURL, nil
+}
+
+// resolveURLs fetches the URLs for photoGUIDs in chunks of chunkSize.
+func (c *Client) resolveURLs(ctx context.Context, baseURL string, photoGUIDs []string) (map[string]string, error) {
 	allURLs := make(map[string]string)
-	for i := 0; i < len(apiResponse.PhotoGUIDs); i += chunkSize {
+	for i := 0; i < len(photoGUIDs); i += chunkSize {
 		end := i + chunkSize
-		if end > len(apiResponse.PhotoGUIDs) {
-			end = len(apiResponse.PhotoGUIDs)
+		if end > len(photoGUIDs) {
+			end = len(photoGUIDs)
 		}
-		chunk := apiResponse.PhotoGUIDs[i:end]
+		chunk := photoGUIDs[i:end]
 
-		fmt.Printf("Getting URLs for chunk %d-%d of %d photos\n", i, end, len(apiResponse.PhotoGUIDs))
-		urls, err := c.getURLs(redirectedBaseURL, chunk)
+		c.debugf("Getting URLs for chunk %d-%d of %d photos\n", i, end, len(photoGUIDs))
+		start := time.Now()
+		urls, err := c.getURLs(ctx, baseURL, chunk, i)
 		if err != nil {
 			return nil, fmt.Errorf("getting URLs for chunk: %w", err)
 		}
-		fmt.Printf("Got %d URLs for chunk\n", len(urls))
+		c.debugf("Got %d URLs for chunk\n", len(urls))
+		c.trace.chunkResolved(ChunkInfo{
+			Start:    i,
+			End:      end,
+			Total:    len(photoGUIDs),
+			URLs:     len(urls),
+			Duration: time.Since(start),
+		})
 
 		for k, v := range urls {
 			allURLs[k] = v
-			fmt.Printf("URL for %s: %s\n", k, v)
+			c.debugf("URL for %s: %s\n", k, v)
 		}
 	}
 
-	fmt.Printf("Total URLs collected: %d\n", len(allURLs))
-	enrichedPhotos := enrichImagesWithURLs(apiResponse, allURLs)
-	fmt.Printf("Enriched %d photos with URLs\n", len(enrichedPhotos))
+	return allURLs, nil
+}
 
-	return &Response{
-		Metadata: apiResponse.Metadata,
-		Photos:   enrichedPhotos,
-	}, nil
+// debugf writes a debug message to the configured debug output
+func (c *Client) debugf(format string, args ...interface{}) {
+	if c.debugOutput != nil {
+		fmt.Fprintf(c.debugOutput, format, args...)
+	}
 }
 
 const base62CharSet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
@@ -110,13 +363,13 @@ func getBaseURL(token string) string {
 	return fmt.Sprintf("https://p%s-sharedstreams.icloud.com/%s/sharedstreams", partitionStr, token)
 }
 
-func (c *Client) getRedirectedBaseURL(baseURL, token string) (string, error) {
-	req, err := http.NewRequest(http.MethodGet, baseURL, nil)
+func (c *Client) getRedirectedBaseURL(ctx context.Context, baseURL, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL, nil)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req, EndpointDiscovery)
 	if err != nil {
 		return "", err
 	}
@@ -125,13 +378,81 @@ func (c *Client) getRedirectedBaseURL(baseURL, token string) (string, error) {
 	if resp.StatusCode == http.StatusPermanentRedirect || resp.StatusCode == http.StatusTemporaryRedirect {
 		location := resp.Header.Get("Location")
 		if location != "" {
-			return strings.TrimSuffix(location, "/"), nil
+			redirected := strings.TrimSuffix(location, "/")
+			c.redirectFollowed(ctx, RedirectInfo{StatusCode: resp.StatusCode, From: baseURL, To: redirected})
+			return redirected, nil
 		}
 	}
 
 	return baseURL, nil
 }
 
+// do sends req, waiting for the rate limiter of the request's host first and
+// retrying transient failures if the client is configured to do so.
+func (c *Client) do(req *http.Request, endpoint string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		if c.limiter != nil {
+			if err := c.limiter.wait(req.Context(), req.URL.Host); err != nil {
+				return nil, err
+			}
+		}
+
+		info := RequestInfo{
+			Endpoint: endpoint,
+			Method:   req.Method,
+			URL:      req.URL.String(),
+			Attempt:  attempt,
+		}
+		c.trace.requestStart(info)
+
+		start := time.Now()
+		resp, err := c.httpClient.Do(req)
+
+		done := RequestDoneInfo{RequestInfo: info, Duration: time.Since(start), Err: err}
+		if resp != nil {
+			done.StatusCode = resp.StatusCode
+		}
+		c.trace.requestDone(done)
+
+		if attempt >= c.maxRetries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		delay := c.retryBackoff << attempt
+		c.trace.retryScheduled(RetryInfo{RequestInfo: info, StatusCode: done.StatusCode, Err: err, Delay: delay})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// shouldRetry reports whether a request failed in a way that may succeed
+// when tried again.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
 var defaultHeaders = map[string]string{
 	"Origin":          "https://www.icloud.com",
 	"Accept-Language": "en-US,en;q=0.8",
@@ -175,36 +496,39 @@ type rawDerivative struct {
 	URL      string `json:"url,omitempty"`
 }
 
-func parseDate(date string) time.Time {
-	t, err := time.Parse(time.RFC3339, date)
-	if err != nil {
-		return time.Time{}
-	}
-	return t
-}
+func (c *Client) getAPIResponse(ctx context.Context, baseURL, ctag string) (resp *APIResponse, err error) {
+	ctx, span := c.startSpan(ctx, EndpointWebstream, attrHost.String(hostOf(baseURL)))
+	defer func() {
+		if resp != nil {
+			span.SetAttributes(attrPhotoCount.Int(len(resp.PhotoGUIDs)))
+		}
+		endSpan(span, err)
+	}()
 
-func (c *Client) getAPIResponse(baseURL string) (*APIResponse, error) {
-	return c.getAPIResponseWithRetry(baseURL, 0)
+	return c.getAPIResponseWithRetry(ctx, baseURL, ctag, 0)
 }
 
-func (c *Client) getAPIResponseWithRetry(baseURL string, retryCount int) (*APIResponse, error) {
+func (c *Client) getAPIResponseWithRetry(ctx context.Context, baseURL, ctag string, retryCount int) (*APIResponse, error) {
 	if retryCount > 2 {
 		return nil, fmt.Errorf("too many redirects")
 	}
 
 	url := fmt.Sprintf("%s/webstream", baseURL)
-	fmt.Printf("Requesting URL: %s\n", url)
+	c.debugf("Requesting URL: %s\n", url)
 
 	payload := map[string]interface{}{
 		"streamCtag": nil,
 	}
+	if ctag != "" {
+		payload["streamCtag"] = ctag
+	}
 
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("marshaling payload: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payloadBytes))
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
@@ -213,20 +537,20 @@ func (c *Client) getAPIResponseWithRetry(baseURL string, retryCount int) (*APIRe
 		req.Header.Set(key, value)
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req, EndpointWebstream)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
-	fmt.Printf("Response Status: %s\n", resp.Status)
+	c.debugf("Response Status: %s\n", resp.Status)
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("reading response body: %w", err)
 	}
 
-	fmt.Printf("Response Body: %s\n", string(body))
+	c.debugf("Response Body: %s\n", string(body))
 
 	// Handle Apple-specific 330 Moved Location redirect
 	if resp.StatusCode == 330 {
@@ -238,7 +562,7 @@ func (c *Client) getAPIResponseWithRetry(baseURL string, retryCount int) (*APIRe
 		}
 
 		if redirect.XAppleMmeHost != "" {
-			fmt.Printf("Redirecting to host: %s\n", redirect.XAppleMmeHost)
+			c.debugf("Redirecting to host: %s\n", redirect.XAppleMmeHost)
 			// Extract token from original baseURL
 			parts := strings.Split(baseURL, "/")
 			if len(parts) < 4 {
@@ -248,10 +572,12 @@ func (c *Client) getAPIResponseWithRetry(baseURL string, retryCount int) (*APIRe
 			
 			// Build new baseURL with redirected host
 			newBaseURL := fmt.Sprintf("https://%s/%s/sharedstreams", redirect.XAppleMmeHost, token)
-			fmt.Printf("New baseURL: %s\n", newBaseURL)
+			c.debugf("New baseURL: %s\n", newBaseURL)
 			
+			c.redirectFollowed(ctx, RedirectInfo{StatusCode: resp.StatusCode, From: baseURL, To: newBaseURL})
+
 			// Retry with new URL
-			return c.getAPIResponseWithRetry(newBaseURL, retryCount+1)
+			return c.getAPIResponseWithRetry(ctx, newBaseURL, ctag, retryCount+1)
 		}
 		return nil, fmt.Errorf("redirect response missing X-Apple-MMe-Host")
 	}
@@ -270,14 +596,14 @@ func (c *Client) getAPIResponseWithRetry(baseURL string, retryCount int) (*APIRe
 			return nil, fmt.Errorf("unmarshaling photo: %w", err)
 		}
 
-		height, _ := strconv.Atoi(rawPhoto.Height)
-		width, _ := strconv.Atoi(rawPhoto.Width)
+		height := int(c.parseInt(rawPhoto.PhotoGUID, "height", rawPhoto.Height))
+		width := int(c.parseInt(rawPhoto.PhotoGUID, "width", rawPhoto.Width))
 
 		derivatives := make(map[string]Derivative)
 		for key, rawDeriv := range rawPhoto.Derivatives {
-			fileSize, _ := strconv.ParseInt(rawDeriv.FileSize, 10, 64)
-			width, _ := strconv.Atoi(rawDeriv.Width)
-			height, _ := strconv.Atoi(rawDeriv.Height)
+			fileSize := c.parseInt(rawPhoto.PhotoGUID, "derivatives."+key+".fileSize", rawDeriv.FileSize)
+			width := int(c.parseInt(rawPhoto.PhotoGUID, "derivatives."+key+".width", rawDeriv.Width))
+			height := int(c.parseInt(rawPhoto.PhotoGUID, "derivatives."+key+".height", rawDeriv.Height))
 
 			derivatives[key] = Derivative{
 				Checksum: rawDeriv.Checksum,
@@ -291,8 +617,8 @@ func (c *Client) getAPIResponseWithRetry(baseURL string, retryCount int) (*APIRe
 			BatchGUID:           rawPhoto.BatchGUID,
 			Derivatives:        derivatives,
 			ContributorLastName: rawPhoto.ContributorLastName,
-			BatchDateCreated:   parseDate(rawPhoto.BatchDateCreated),
-			DateCreated:        parseDate(rawPhoto.DateCreated),
+			BatchDateCreated:   c.parseDate(rawPhoto.PhotoGUID, "batchDateCreated", rawPhoto.BatchDateCreated),
+			DateCreated:        c.parseDate(rawPhoto.PhotoGUID, "dateCreated", rawPhoto.DateCreated),
 			ContributorFirstName: rawPhoto.ContributorFirstName,
 			PhotoGUID:          rawPhoto.PhotoGUID,
 			ContributorFullName: rawPhoto.ContributorFullName,
@@ -306,7 +632,7 @@ func (c *Client) getAPIResponseWithRetry(baseURL string, retryCount int) (*APIRe
 		photoGUIDs = append(photoGUIDs, photo.PhotoGUID)
 	}
 
-	itemsReturned, _ := strconv.Atoi(raw.ItemsReturned)
+	itemsReturned := int(c.parseInt("", "itemsReturned", raw.ItemsReturned))
 
 	return &APIResponse{
 		Photos:     photos,
@@ -329,11 +655,21 @@ type urlResponse struct {
 	} `json:"items"`
 }
 
-func (c *Client) getURLs(baseURL string, photoGUIDs []string) (map[string]string, error) {
-	return c.getURLsWithRetry(baseURL, photoGUIDs, 0)
+func (c *Client) getURLs(ctx context.Context, baseURL string, photoGUIDs []string, offset int) (urls map[string]string, err error) {
+	ctx, span := c.startSpan(ctx, EndpointWebAssetURLs,
+		attrHost.String(hostOf(baseURL)),
+		attrChunkStart.Int(offset),
+		attrPhotoCount.Int(len(photoGUIDs)),
+	)
+	defer func() {
+		span.SetAttributes(attrURLCount.Int(len(urls)))
+		endSpan(span, err)
+	}()
+
+	return c.getURLsWithRetry(ctx, baseURL, photoGUIDs, 0)
 }
 
-func (c *Client) getURLsWithRetry(baseURL string, photoGUIDs []string, retryCount int) (map[string]string, error) {
+func (c *Client) getURLsWithRetry(ctx context.Context, baseURL string, photoGUIDs []string, retryCount int) (map[string]string, error) {
 	if retryCount > 2 {
 		return nil, fmt.Errorf("too many redirects")
 	}
@@ -352,9 +688,9 @@ func (c *Client) getURLsWithRetry(baseURL string, photoGUIDs []string, retryCoun
 	// Convert to string and back to match TypeScript behavior
 	payloadStr := string(payloadBytes)
 
-	fmt.Printf("URL Request Payload: %s\n", payloadStr)
+	c.debugf("URL Request Payload: %s\n", payloadStr)
 
-	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(payloadStr))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(payloadStr))
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
@@ -363,22 +699,22 @@ func (c *Client) getURLsWithRetry(baseURL string, photoGUIDs []string, retryCoun
 		req.Header.Set(key, value)
 	}
 
-	fmt.Printf("Requesting URLs from: %s\n", url)
-	fmt.Printf("Requesting URLs for %d photos\n", len(photoGUIDs))
-	resp, err := c.httpClient.Do(req)
+	c.debugf("Requesting URLs from: %s\n", url)
+	c.debugf("Requesting URLs for %d photos\n", len(photoGUIDs))
+	resp, err := c.do(req, EndpointWebAssetURLs)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
-	fmt.Printf("URL Response Status: %s\n", resp.Status)
+	c.debugf("URL Response Status: %s\n", resp.Status)
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("reading response body: %w", err)
 	}
 
-	fmt.Printf("URL Response Body: %s\n", string(body))
+	c.debugf("URL Response Body: %s\n", string(body))
 
 	// Handle Apple-specific 330 Moved Location redirect
 	if resp.StatusCode == 330 {
@@ -390,7 +726,7 @@ func (c *Client) getURLsWithRetry(baseURL string, photoGUIDs []string, retryCoun
 		}
 
 		if redirect.XAppleMmeHost != "" {
-			fmt.Printf("Redirecting URLs to host: %s\n", redirect.XAppleMmeHost)
+			c.debugf("Redirecting URLs to host: %s\n", redirect.XAppleMmeHost)
 			// Extract token from original baseURL
 			parts := strings.Split(baseURL, "/")
 			if len(parts) < 4 {
@@ -400,10 +736,12 @@ func (c *Client) getURLsWithRetry(baseURL string, photoGUIDs []string, retryCoun
 			
 			// Build new baseURL with redirected host
 			newBaseURL := fmt.Sprintf("https://%s/%s/sharedstreams", redirect.XAppleMmeHost, token)
-			fmt.Printf("New URLs baseURL: %s\n", newBaseURL)
+			c.debugf("New URLs baseURL: %s\n", newBaseURL)
 			
+			c.redirectFollowed(ctx, RedirectInfo{StatusCode: resp.StatusCode, From: baseURL, To: newBaseURL})
+
 			// Retry with new URL
-			return c.getURLsWithRetry(newBaseURL, photoGUIDs, retryCount+1)
+			return c.getURLsWithRetry(ctx, newBaseURL, photoGUIDs, retryCount+1)
 		}
 		return nil, fmt.Errorf("redirect response missing X-Apple-MMe-Host")
 	}
@@ -417,27 +755,27 @@ func (c *Client) getURLsWithRetry(baseURL string, photoGUIDs []string, retryCoun
 	for itemID, item := range response.Items {
 		url := fmt.Sprintf("https://%s%s", item.URLLocation, item.URLPath)
 		urls[itemID] = url
-		fmt.Printf("Generated URL for %s: %s\n", itemID, url)
+		c.debugf("Generated URL for %s: %s\n", itemID, url)
 	}
 
 	return urls, nil
 }
 
-func enrichImagesWithURLs(apiResp *APIResponse, urls map[string]string) []Image {
+func (c *Client) enrichImagesWithURLs(apiResp *APIResponse, urls map[string]string) []Image {
 	images := make([]Image, 0, len(apiResp.Photos))
 	
-	fmt.Printf("Enriching %d photos with %d URLs\n", len(apiResp.PhotoGUIDs), len(urls))
+	c.debugf("Enriching %d photos with %d URLs\n", len(apiResp.PhotoGUIDs), len(urls))
 	for _, photoGUID := range apiResp.PhotoGUIDs {
 		if photo, ok := apiResp.Photos[photoGUID]; ok {
-			fmt.Printf("Processing photo %s with %d derivatives\n", photoGUID, len(photo.Derivatives))
+			c.debugf("Processing photo %s with %d derivatives\n", photoGUID, len(photo.Derivatives))
 			for derivativeKey, derivative := range photo.Derivatives {
 				// Try to find URL by derivative checksum
 				if url, ok := urls[derivative.Checksum]; ok {
-					fmt.Printf("Found URL for %s (checksum %s): %s\n", photoGUID, derivative.Checksum, url)
+					c.debugf("Found URL for %s (checksum %s): %s\n", photoGUID, derivative.Checksum, url)
 					derivative.URL = &url
 					photo.Derivatives[derivativeKey] = derivative
 				} else {
-					fmt.Printf("No URL found for %s (checksum %s)\n", photoGUID, derivative.Checksum)
+					c.debugf("No URL found for %s (checksum %s)\n", photoGUID, derivative.Checksum)
 				}
 			}
 			images = append(images, photo)
@@ -0,0 +1,88 @@
+package icloudalbum
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/Shogoki/icloud-shared-album-go"
+
+// Span attributes. The album token grants access to the album, so it is
+// never recorded; spans carry a short hash of it instead.
+const (
+	attrAlbumID    = attribute.Key("icloud.album.id")
+	attrHost       = attribute.Key("icloud.host")
+	attrPhotoCount = attribute.Key("icloud.photos.count")
+	attrURLCount   = attribute.Key("icloud.urls.count")
+	attrChunkStart = attribute.Key("icloud.chunk.start")
+	attrStatusCode = attribute.Key("http.response.status_code")
+	attrRedirectTo = attribute.Key("icloud.redirect.host")
+)
+
+// WithTracerProvider sets the OpenTelemetry tracer provider used to create
+// spans around album fetching. By default the global provider is used,
+// which does nothing unless one has been registered with
+// otel.SetTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Client) {
+		c.tracerProvider = tp
+	}
+}
+
+func (c *Client) tracer() trace.Tracer {
+	tp := c.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(instrumentationName)
+}
+
+func (c *Client) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return c.tracer().Start(ctx, "icloudalbum."+name, trace.WithAttributes(attrs...))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// redirectFollowed reports a followed redirect to the trace hooks and as an
+// event on the current span.
+func (c *Client) redirectFollowed(ctx context.Context, info RedirectInfo) {
+	c.trace.redirectFollowed(info)
+	trace.SpanFromContext(ctx).AddEvent("redirect", trace.WithAttributes(
+		attrStatusCode.Int(info.StatusCode),
+		attrRedirectTo.String(hostOf(info.To)),
+	))
+}
+
+// albumIDAttr identifies an album in spans without exposing its token.
+func albumIDAttr(token string) attribute.KeyValue {
+	return attrAlbumID.String(albumID(token))
+}
+
+// albumID identifies an album by a hash of its token, which must stay
+// secret.
+func albumID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
+}
+
+// hostOf returns the host of rawURL, or an empty string if it can't be parsed.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,204 @@
+package icloudalbum
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// roundTripFunc serves the requests of a Client in process, so tests can
+// answer for the iCloud hosts
+type roundTripFunc func(*http.Request) *http.Response
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r), nil
+}
+
+// fakeICloud answers the discovery, webstream and webasseturls requests of
+// an album with one photo. redirect makes discovery move the album to
+// another host, webstreamStatus replaces a successful webstream response.
+func fakeICloud(redirect bool, webstreamStatus int) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) *http.Response {
+		w := httptest.NewRecorder()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/sharedstreams") && redirect && r.URL.Host != "p99-sharedstreams.icloud.com":
+			w.Header().Set("Location", "https://p99-sharedstreams.icloud.com"+r.URL.Path)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		case strings.HasSuffix(r.URL.Path, "/sharedstreams"):
+			w.WriteHeader(http.StatusOK)
+		case strings.HasSuffix(r.URL.Path, "/webstream") && webstreamStatus != 0:
+			w.WriteHeader(webstreamStatus)
+			w.WriteString("unavailable")
+		case strings.HasSuffix(r.URL.Path, "/webstream"):
+			w.WriteString(`{"streamName":"Album","streamCtag":"ctag","itemsReturned":"1","photos":[` +
+				`{"photoGuid":"P1","derivatives":{"342":{"checksum":"c1","fileSize":"10","width":"342","height":"256"}}}]}`)
+		case strings.HasSuffix(r.URL.Path, "/webasseturls"):
+			w.WriteString(`{"items":{"c1":{"url_location":"cvws.icloud-content.com","url_path":"/c1"}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return w.Result()
+	})
+}
+
+func TestSpans(t *testing.T) {
+	const token = "B0z5qAGN1JIFd3y"
+
+	type span struct {
+		name   string
+		status codes.Code
+		attrs  map[attribute.Key]attribute.Value
+		events []string
+	}
+
+	tests := []struct {
+		name            string
+		call            func(ctx context.Context, c *Client) error
+		redirect        bool
+		webstreamStatus int
+		// want lists the spans in the order they ended
+		want []span
+	}{
+		{
+			name: "GetImages",
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.GetImagesContext(ctx, token)
+				return err
+			},
+			want: []span{
+				{name: "icloudalbum.discover", attrs: map[attribute.Key]attribute.Value{
+					attrHost: attribute.StringValue("p61-sharedstreams.icloud.com"),
+				}},
+				{name: "icloudalbum.webstream", attrs: map[attribute.Key]attribute.Value{
+					attrHost:       attribute.StringValue("p61-sharedstreams.icloud.com"),
+					attrPhotoCount: attribute.IntValue(1),
+				}},
+				{name: "icloudalbum.webasseturls", attrs: map[attribute.Key]attribute.Value{
+					attrChunkStart: attribute.IntValue(0),
+					attrPhotoCount: attribute.IntValue(1),
+					attrURLCount:   attribute.IntValue(1),
+				}},
+				{name: "icloudalbum.GetImages", attrs: map[attribute.Key]attribute.Value{
+					attrAlbumID:    attribute.StringValue(albumID(token)),
+					attrPhotoCount: attribute.IntValue(1),
+				}},
+			},
+		},
+		{
+			name: "redirect",
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.GetAlbumContext(ctx, token)
+				return err
+			},
+			redirect: true,
+			want: []span{
+				{name: "icloudalbum.discover", events: []string{"redirect"}, attrs: map[attribute.Key]attribute.Value{
+					attrHost: attribute.StringValue("p99-sharedstreams.icloud.com"),
+				}},
+				{name: "icloudalbum.webstream", attrs: map[attribute.Key]attribute.Value{
+					attrHost: attribute.StringValue("p99-sharedstreams.icloud.com"),
+				}},
+				{name: "icloudalbum.GetAlbum", attrs: map[attribute.Key]attribute.Value{
+					attrAlbumID: attribute.StringValue(albumID(token)),
+				}},
+			},
+		},
+		{
+			name: "error",
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.GetAlbumContext(ctx, token)
+				return err
+			},
+			webstreamStatus: http.StatusServiceUnavailable,
+			want: []span{
+				{name: "icloudalbum.discover"},
+				{name: "icloudalbum.webstream", status: codes.Error, events: []string{"exception"}},
+				{name: "icloudalbum.GetAlbum", status: codes.Error, events: []string{"exception"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			c := NewClient(WithTracerProvider(tp), WithDebugOutput(nil))
+			c.httpClient.Transport = fakeICloud(tt.redirect, tt.webstreamStatus)
+
+			err := tt.call(context.Background(), c)
+			if (err != nil) != (tt.webstreamStatus != 0) {
+				t.Fatalf("call error = %v", err)
+			}
+
+			spans := recorder.Ended()
+			if len(spans) != len(tt.want) {
+				t.Fatalf("got %d spans, want %d", len(spans), len(tt.want))
+			}
+			root := spans[len(spans)-1]
+			for i, want := range tt.want {
+				got := spans[i]
+				if got.Name() != want.name {
+					t.Errorf("span %d name = %q, want %q", i, got.Name(), want.name)
+				}
+				if got.Status().Code != want.status {
+					t.Errorf("%s status = %v, want %v", want.name, got.Status().Code, want.status)
+				}
+				if i < len(spans)-1 && got.Parent().SpanID() != root.SpanContext().SpanID() {
+					t.Errorf("%s is not a child of %s", want.name, root.Name())
+				}
+
+				attrs := make(map[attribute.Key]attribute.Value)
+				for _, attr := range got.Attributes() {
+					if strings.Contains(attr.Value.Emit(), token) {
+						t.Errorf("%s attribute %s exposes the token", want.name, attr.Key)
+					}
+					attrs[attr.Key] = attr.Value
+				}
+				for key, value := range want.attrs {
+					if attrs[key] != value {
+						t.Errorf("%s attribute %s = %v, want %v", want.name, key, attrs[key].Emit(), value.Emit())
+					}
+				}
+
+				var events []string
+				for _, event := range got.Events() {
+					events = append(events, event.Name)
+				}
+				if strings.Join(events, ",") != strings.Join(want.events, ",") {
+					t.Errorf("%s events = %v, want %v", want.name, events, want.events)
+				}
+			}
+		})
+	}
+}
+
+func TestAlbumID(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+	}{
+		// The first 6 bytes of the SHA-256 of the token
+		{"B0z5qAGN1JIFd3y", "75633e8cb883"},
+		{"", "e3b0c44298fc"},
+		{"abc", "ba7816bf8f01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			got := albumID(tt.token)
+			if got != tt.want || len(got) != 12 {
+				t.Errorf("albumID(%q) = %q, want %q", tt.token, got, tt.want)
+			}
+			if attr := albumIDAttr(tt.token); attr.Key != attrAlbumID || attr.Value.AsString() != got {
+				t.Errorf("albumIDAttr(%q) = %v", tt.token, attr)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package icloudalbum
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHostLimiterReserve(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	type reservation struct {
+		host  string
+		after time.Duration
+		want  time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		// reservations are made in order, after is relative to start
+		reservations []reservation
+	}{
+		{
+			name:  "burst",
+			rate:  1,
+			burst: 3,
+			reservations: []reservation{
+				{"a", 0, 0},
+				{"a", 0, 0},
+				{"a", 0, 0},
+				{"a", 0, time.Second},
+				{"a", 0, 2 * time.Second},
+			},
+		},
+		{
+			name:  "burst below one",
+			rate:  2,
+			burst: 0,
+			reservations: []reservation{
+				{"a", 0, 0},
+				{"a", 0, 500 * time.Millisecond},
+			},
+		},
+		{
+			name:  "refill",
+			rate:  2,
+			burst: 1,
+			reservations: []reservation{
+				{"a", 0, 0},
+				{"a", 250 * time.Millisecond, 250 * time.Millisecond},
+				{"a", 2 * time.Second, 0},
+			},
+		},
+		{
+			name:  "refill stops at burst",
+			rate:  10,
+			burst: 2,
+			reservations: []reservation{
+				{"a", 0, 0},
+				{"a", 0, 0},
+				{"a", time.Hour, 0},
+				{"a", time.Hour, 0},
+				{"a", time.Hour, 100 * time.Millisecond},
+			},
+		},
+		{
+			name:  "hosts are isolated",
+			rate:  1,
+			burst: 1,
+			reservations: []reservation{
+				{"a", 0, 0},
+				{"b", 0, 0},
+				{"a", 0, time.Second},
+				{"c", 0, 0},
+				{"b", 0, time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newHostLimiter(tt.rate, tt.burst)
+			for i, r := range tt.reservations {
+				if got := l.reserve(r.host, start.Add(r.after)); got != r.want {
+					t.Errorf("reservation %d for %s = %v, want %v", i, r.host, got, r.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHostLimiterWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		wantErr error
+	}{
+		{"allowed", time.Second, nil},
+		{"cancelled while waiting", 10 * time.Millisecond, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One request every 100ms; the first one uses the burst
+			l := newHostLimiter(10, 1)
+			if err := l.wait(context.Background(), "a"); err != nil {
+				t.Fatalf("first wait() error = %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			start := time.Now()
+			err := l.wait(ctx, "a")
+			elapsed := time.Since(start)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("wait() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && elapsed < 50*time.Millisecond {
+				t.Errorf("wait() returned after %v, want about 100ms", elapsed)
+			}
+			if err != nil && elapsed > 90*time.Millisecond {
+				t.Errorf("wait() returned after %v, want it to stop on cancellation", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package icloudalbum
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot file format written by
+// SaveSnapshot
+const SnapshotVersion = 1
+
+// snapshotTimeFormat names snapshot files so they sort chronologically
+const snapshotTimeFormat = "20060102T150405Z"
+
+// Snapshot is the state of an album at a point in time
+type Snapshot struct {
+	Version   int       `json:"version"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Metadata  Metadata  `json:"metadata"`
+	Photos    []Image   `json:"photos"`
+}
+
+// NewSnapshot captures resp as fetched at fetchedAt
+func NewSnapshot(resp *Response, fetchedAt time.Time) *Snapshot {
+	photos := make([]Image, len(resp.Photos))
+	copy(photos, resp.Photos)
+
+	return &Snapshot{
+		Version:   SnapshotVersion,
+		FetchedAt: fetchedAt.UTC(),
+		Metadata:  resp.Metadata,
+		Photos:    photos,
+	}
+}
+
+// Response returns the album state of the snapshot
+func (s *Snapshot) Response() *Response {
+	return &Response{Metadata: s.Metadata, Photos: s.Photos}
+}
+
+// AlbumSnapshotDir returns the directory in dir that keeps the snapshots of
+// the album with token, named by a hash of the token so it is not exposed
+func AlbumSnapshotDir(dir, token string) string {
+	return filepath.Join(dir, albumID(token))
+}
+
+// SnapshotPath returns the path of a snapshot fetched at fetchedAt in dir,
+// named so that ListSnapshots returns snapshots in chronological order
+func SnapshotPath(dir string, fetchedAt time.Time) string {
+	return filepath.Join(dir, "snapshot-"+fetchedAt.UTC().Format(snapshotTimeFormat)+".json")
+}
+
+// ListSnapshots returns the paths of the snapshots in dir named by
+// SnapshotPath, oldest first
+func ListSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "snapshot-") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// SaveSnapshot writes s to path. The file is replaced atomically, so a
+// reader never sees a partially written snapshot.
+func SaveSnapshot(path string, s *Snapshot) error {
+	if s.Version == 0 {
+		s.Version = SnapshotVersion
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding snapshot: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot reads a snapshot written by SaveSnapshot
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing snapshot %s: %w", path, err)
+	}
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return nil, fmt.Errorf("snapshot %s has unsupported version %d", path, s.Version)
+	}
+	return &s, nil
+}
+
+// SnapshotDiff describes how an album changed between two snapshots
+type SnapshotDiff struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// OldName and NewName are set if the album was renamed
+	OldName string `json:"oldName,omitempty"`
+	NewName string `json:"newName,omitempty"`
+
+	Added             []Image            `json:"added"`
+	Removed           []Image            `json:"removed"`
+	CaptionChanges    []CaptionChange    `json:"captionChanges"`
+	DerivativeChanges []DerivativeChange `json:"derivativeChanges"`
+}
+
+// CaptionChange is an edited photo caption
+type CaptionChange struct {
+	PhotoGUID string `json:"photoGuid"`
+	Old       string `json:"old"`
+	New       string `json:"new"`
+}
+
+// DerivativeChange is a derivative of a photo that was added, removed or
+// replaced. Old is nil for added and New is nil for removed derivatives.
+type DerivativeChange struct {
+	PhotoGUID string      `json:"photoGuid"`
+	Key       string      `json:"key"`
+	Old       *Derivative `json:"old,omitempty"`
+	New       *Derivative `json:"new,omitempty"`
+}
+
+// Empty reports whether the snapshots describe the same album state
+func (d *SnapshotDiff) Empty() bool {
+	return d.OldName == d.NewName && len(d.Added) == 0 && len(d.Removed) == 0 &&
+		len(d.CaptionChanges) == 0 && len(d.DerivativeChanges) == 0
+}
+
+// Diff compares two snapshots of the same album. Added and changed photos
+// are listed in the order of to, removed photos in the order of from.
+// Derivatives are compared by checksum, size and dimensions only, as their
+// URLs change on every fetch.
+func Diff(from, to *Snapshot) *SnapshotDiff {
+	diff := &SnapshotDiff{
+		From:              from.FetchedAt,
+		To:                to.FetchedAt,
+		Added:             []Image{},
+		Removed:           []Image{},
+		CaptionChanges:    []CaptionChange{},
+		DerivativeChanges: []DerivativeChange{},
+	}
+
+	if from.Metadata.StreamName != to.Metadata.StreamName {
+		diff.OldName = from.Metadata.StreamName
+		diff.NewName = to.Metadata.StreamName
+	}
+
+	previous := make(map[string]Image, len(from.Photos))
+	for _, photo := range from.Photos {
+		previous[photo.PhotoGUID] = photo
+	}
+	current := make(map[string]bool, len(to.Photos))
+
+	for _, photo := range to.Photos {
+		current[photo.PhotoGUID] = true
+
+		old, ok := previous[photo.PhotoGUID]
+		if !ok {
+			diff.Added = append(diff.Added, photo)
+			continue
+		}
+
+		if old.Caption != photo.Caption {
+			diff.CaptionChanges = append(diff.CaptionChanges, CaptionChange{
+				PhotoGUID: photo.PhotoGUID,
+				Old:       old.Caption,
+				New:       photo.Caption,
+			})
+		}
+		diff.DerivativeChanges = append(diff.DerivativeChanges, diffDerivatives(old, photo)...)
+	}
+
+	for _, photo := range from.Photos {
+		if !current[photo.PhotoGUID] {
+			diff.Removed = append(diff.Removed, photo)
+		}
+	}
+
+	return diff
+}
+
+// diffDerivatives returns the derivative changes from old to photo,
+// ordered by key
+func diffDerivatives(old, photo Image) []DerivativeChange {
+	keys := make([]string, 0, len(photo.Derivatives))
+	for key := range photo.Derivatives {
+		keys = append(keys, key)
+	}
+	for key := range old.Derivatives {
+		if _, ok := photo.Derivatives[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []DerivativeChange
+	for _, key := range keys {
+		oldDerivative, hadOld := old.Derivatives[key]
+		newDerivative, hasNew := photo.Derivatives[key]
+		if hadOld && hasNew && sameDerivative(oldDerivative, newDerivative) {
+			continue
+		}
+
+		change := DerivativeChange{PhotoGUID: photo.PhotoGUID, Key: key}
+		if hadOld {
+			change.Old = &oldDerivative
+		}
+		if hasNew {
+			change.New = &newDerivative
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func sameDerivative(a, b Derivative) bool {
+	return a.Checksum == b.Checksum && a.FileSize == b.FileSize &&
+		a.Width == b.Width && a.Height == b.Height
+}
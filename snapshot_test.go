@@ -0,0 +1,100 @@
+package icloudalbum
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	from := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	small := Derivative{Checksum: "s", FileSize: 10, Width: 342, Height: 256, URL: stringPtr("https://example.com/1")}
+	large := Derivative{Checksum: "l", FileSize: 100, Width: 2048, Height: 1536}
+	replaced := Derivative{Checksum: "r", FileSize: 120, Width: 2048, Height: 1536}
+	// refetched only has a new URL, which is not a change
+	refetched := small
+	refetched.URL = stringPtr("https://example.com/2")
+
+	snapshot := func(at time.Time, name string, photos ...Image) *Snapshot {
+		return NewSnapshot(&Response{Metadata: Metadata{StreamName: name}, Photos: photos}, at)
+	}
+	photo := func(guid, caption string, derivatives map[string]Derivative) Image {
+		return Image{PhotoGUID: guid, Caption: caption, Derivatives: derivatives}
+	}
+
+	tests := []struct {
+		name      string
+		from, to  *Snapshot
+		want      *SnapshotDiff
+		wantEmpty bool
+	}{
+		{
+			name: "unchanged",
+			from: snapshot(from, "Album", photo("A", "a", map[string]Derivative{"342": small})),
+			to:   snapshot(to, "Album", photo("A", "a", map[string]Derivative{"342": refetched})),
+			want: &SnapshotDiff{
+				From: from, To: to,
+				Added: []Image{}, Removed: []Image{},
+				CaptionChanges: []CaptionChange{}, DerivativeChanges: []DerivativeChange{},
+			},
+			wantEmpty: true,
+		},
+		{
+			name: "renamed",
+			from: snapshot(from, "Album"),
+			to:   snapshot(to, "Summer"),
+			want: &SnapshotDiff{
+				From: from, To: to,
+				OldName: "Album", NewName: "Summer",
+				Added: []Image{}, Removed: []Image{},
+				CaptionChanges: []CaptionChange{}, DerivativeChanges: []DerivativeChange{},
+			},
+		},
+		{
+			name: "added and removed",
+			from: snapshot(from, "Album", photo("A", "", nil), photo("B", "", nil), photo("C", "", nil)),
+			to:   snapshot(to, "Album", photo("E", "", nil), photo("B", "", nil), photo("D", "", nil)),
+			want: &SnapshotDiff{
+				From: from, To: to,
+				Added:          []Image{photo("E", "", nil), photo("D", "", nil)},
+				Removed:        []Image{photo("A", "", nil), photo("C", "", nil)},
+				CaptionChanges: []CaptionChange{}, DerivativeChanges: []DerivativeChange{},
+			},
+		},
+		{
+			name: "caption and derivatives changed",
+			from: snapshot(from, "Album",
+				photo("A", "old", map[string]Derivative{"342": small, "2048": large}),
+				photo("B", "same", map[string]Derivative{"2048": large}),
+			),
+			to: snapshot(to, "Album",
+				photo("B", "same", map[string]Derivative{"342": small, "2048": replaced}),
+				photo("A", "new", map[string]Derivative{"2048": large}),
+			),
+			want: &SnapshotDiff{
+				From: from, To: to,
+				Added: []Image{}, Removed: []Image{},
+				CaptionChanges: []CaptionChange{{PhotoGUID: "A", Old: "old", New: "new"}},
+				DerivativeChanges: []DerivativeChange{
+					{PhotoGUID: "B", Key: "2048", Old: &large, New: &replaced},
+					{PhotoGUID: "B", Key: "342", New: &small},
+					{PhotoGUID: "A", Key: "342", Old: &small},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(tt.from, tt.to)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+			if got.Empty() != tt.wantEmpty {
+				t.Errorf("Empty() = %v, want %v", got.Empty(), tt.wantEmpty)
+			}
+		})
+	}
+}
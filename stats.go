@@ -0,0 +1,148 @@
+package icloudalbum
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// AlbumStats summarises the contents of an album
+type AlbumStats struct {
+	Photos int `json:"photos"`
+	Videos int `json:"videos"`
+
+	Contributors []ContributorStats `json:"contributors"`
+
+	// PerDay and PerMonth count items by creation date, keyed by
+	// "2006-01-02" and "2006-01"
+	PerDay   map[string]int `json:"perDay"`
+	PerMonth map[string]int `json:"perMonth"`
+
+	// BytesPerDerivative sums Derivative.FileSize by derivative key
+	BytesPerDerivative map[string]int64 `json:"bytesPerDerivative"`
+	TotalBytes         int64            `json:"totalBytes"`
+
+	// Resolutions counts items by their original dimensions, ordered by
+	// descending count
+	Resolutions []ResolutionStats `json:"resolutions"`
+
+	// FirstDate and LastDate are the creation dates of the oldest and
+	// newest item, nil for an empty album
+	FirstDate *time.Time `json:"firstDate,omitempty"`
+	LastDate  *time.Time `json:"lastDate,omitempty"`
+	// SpanDays is the number of calendar days from FirstDate to LastDate,
+	// inclusive
+	SpanDays int `json:"spanDays"`
+}
+
+// ContributorStats counts the items added by a single contributor
+type ContributorStats struct {
+	Name   string `json:"name"`
+	Photos int    `json:"photos"`
+	Videos int    `json:"videos"`
+}
+
+// ResolutionStats counts the items with a given resolution
+type ResolutionStats struct {
+	Resolution string `json:"resolution"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Count      int    `json:"count"`
+}
+
+// Stats computes statistics over resp. Dates are bucketed in the location
+// they were returned in by iCloud.
+func Stats(resp *Response) AlbumStats {
+	stats := AlbumStats{
+		Contributors:       []ContributorStats{},
+		PerDay:             make(map[string]int),
+		PerMonth:           make(map[string]int),
+		BytesPerDerivative: make(map[string]int64),
+		Resolutions:        []ResolutionStats{},
+	}
+
+	contributors := make(map[string]*ContributorStats)
+	resolutions := make(map[string]*ResolutionStats)
+
+	for _, photo := range resp.Photos {
+		video := isVideo(photo)
+		if video {
+			stats.Videos++
+		} else {
+			stats.Photos++
+		}
+
+		contributor, ok := contributors[photo.ContributorFullName]
+		if !ok {
+			contributor = &ContributorStats{Name: photo.ContributorFullName}
+			contributors[photo.ContributorFullName] = contributor
+		}
+		if video {
+			contributor.Videos++
+		} else {
+			contributor.Photos++
+		}
+
+		if created := photo.DateCreated; !created.IsZero() {
+			stats.PerDay[created.Format("2006-01-02")]++
+			stats.PerMonth[created.Format("2006-01")]++
+
+			if stats.FirstDate == nil || created.Before(*stats.FirstDate) {
+				stats.FirstDate = &created
+			}
+			if stats.LastDate == nil || created.After(*stats.LastDate) {
+				stats.LastDate = &created
+			}
+		}
+
+		for key, derivative := range photo.Derivatives {
+			stats.BytesPerDerivative[key] += derivative.FileSize
+			stats.TotalBytes += derivative.FileSize
+		}
+
+		if photo.Width > 0 && photo.Height > 0 {
+			resolution := fmt.Sprintf("%dx%d", photo.Width, photo.Height)
+			r, ok := resolutions[resolution]
+			if !ok {
+				r = &ResolutionStats{Resolution: resolution, Width: photo.Width, Height: photo.Height}
+				resolutions[resolution] = r
+			}
+			r.Count++
+		}
+	}
+
+	for _, contributor := range contributors {
+		stats.Contributors = append(stats.Contributors, *contributor)
+	}
+	sort.Slice(stats.Contributors, func(i, j int) bool {
+		a, b := stats.Contributors[i], stats.Contributors[j]
+		if a.Photos+a.Videos != b.Photos+b.Videos {
+			return a.Photos+a.Videos > b.Photos+b.Videos
+		}
+		return a.Name < b.Name
+	})
+
+	for _, resolution := range resolutions {
+		stats.Resolutions = append(stats.Resolutions, *resolution)
+	}
+	sort.Slice(stats.Resolutions, func(i, j int) bool {
+		a, b := stats.Resolutions[i], stats.Resolutions[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Resolution < b.Resolution
+	})
+
+	if stats.FirstDate != nil {
+		first := truncateToDay(*stats.FirstDate)
+		last := truncateToDay(stats.LastDate.In(stats.FirstDate.Location()))
+		stats.SpanDays = int(last.Sub(first).Hours()/24+0.5) + 1
+	}
+
+	return stats
+}
+
+// truncateToDay returns midnight of the day of t in t's location
+func truncateToDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
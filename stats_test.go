@@ -0,0 +1,133 @@
+package icloudalbum
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	day := func(d, h int) time.Time { return time.Date(2024, 1, d, h, 0, 0, 0, time.UTC) }
+	video := stringPtr("video")
+
+	tests := []struct {
+		name string
+		resp *Response
+		want AlbumStats
+	}{
+		{
+			name: "empty",
+			resp: &Response{},
+			want: AlbumStats{
+				Contributors:       []ContributorStats{},
+				PerDay:             map[string]int{},
+				PerMonth:           map[string]int{},
+				BytesPerDerivative: map[string]int64{},
+				Resolutions:        []ResolutionStats{},
+			},
+		},
+		{
+			name: "album",
+			resp: &Response{Photos: []Image{
+				{
+					ContributorFullName: "Bob",
+					DateCreated:         day(31, 23),
+					Width:               4032, Height: 3024,
+					Derivatives: map[string]Derivative{"342": {FileSize: 10}, "2048": {FileSize: 100}},
+				},
+				{
+					ContributorFullName: "Ada",
+					DateCreated:         day(1, 8),
+					Width:               4032, Height: 3024,
+					Derivatives: map[string]Derivative{"342": {FileSize: 20}},
+				},
+				{
+					ContributorFullName: "Ada",
+					DateCreated:         day(1, 20),
+					MediaAssetType:      video,
+					Width:               1920, Height: 1080,
+					Derivatives: map[string]Derivative{"720p": {FileSize: 1000}},
+				},
+				{ContributorFullName: "Cy", MediaAssetType: video},
+			}},
+			want: AlbumStats{
+				Photos: 2,
+				Videos: 2,
+				Contributors: []ContributorStats{
+					{Name: "Ada", Photos: 1, Videos: 1},
+					{Name: "Bob", Photos: 1},
+					{Name: "Cy", Videos: 1},
+				},
+				PerDay:             map[string]int{"2024-01-01": 2, "2024-01-31": 1},
+				PerMonth:           map[string]int{"2024-01": 3},
+				BytesPerDerivative: map[string]int64{"342": 30, "2048": 100, "720p": 1000},
+				TotalBytes:         1130,
+				Resolutions: []ResolutionStats{
+					{Resolution: "4032x3024", Width: 4032, Height: 3024, Count: 2},
+					{Resolution: "1920x1080", Width: 1920, Height: 1080, Count: 1},
+				},
+				FirstDate: timePtr(day(1, 8)),
+				LastDate:  timePtr(day(31, 23)),
+				SpanDays:  31,
+			},
+		},
+		{
+			name: "single day",
+			resp: &Response{Photos: []Image{
+				{DateCreated: day(5, 23)},
+				{DateCreated: day(5, 1)},
+			}},
+			want: AlbumStats{
+				Photos:             2,
+				Contributors:       []ContributorStats{{Name: "", Photos: 2}},
+				PerDay:             map[string]int{"2024-01-05": 2},
+				PerMonth:           map[string]int{"2024-01": 2},
+				BytesPerDerivative: map[string]int64{},
+				Resolutions:        []ResolutionStats{},
+				FirstDate:          timePtr(day(5, 1)),
+				LastDate:           timePtr(day(5, 23)),
+				SpanDays:           1,
+			},
+		},
+		{
+			name: "across daylight saving time",
+			resp: &Response{Photos: []Image{
+				{DateCreated: time.Date(2024, 3, 30, 12, 0, 0, 0, berlin)},
+				{DateCreated: time.Date(2024, 4, 2, 12, 0, 0, 0, berlin)},
+			}},
+			want: AlbumStats{
+				Photos:             2,
+				Contributors:       []ContributorStats{{Name: "", Photos: 2}},
+				PerDay:             map[string]int{"2024-03-30": 1, "2024-04-02": 1},
+				PerMonth:           map[string]int{"2024-03": 1, "2024-04": 1},
+				BytesPerDerivative: map[string]int64{},
+				Resolutions:        []ResolutionStats{},
+				FirstDate:          timePtr(time.Date(2024, 3, 30, 12, 0, 0, 0, berlin)),
+				LastDate:           timePtr(time.Date(2024, 4, 2, 12, 0, 0, 0, berlin)),
+				SpanDays:           4,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Stats(tt.resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+// berlin switches to daylight saving time on 31 March 2024. Without a
+// time zone database it falls back to a fixed zone
+var berlin = func() *time.Location {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		return time.FixedZone("CET", 3600)
+	}
+	return loc
+}()
@@ -0,0 +1,123 @@
+package icloudalbum
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Granularity is the size of the buckets of a timeline
+type Granularity string
+
+// Supported timeline granularities
+const (
+	ByDay   Granularity = "day"
+	ByMonth Granularity = "month"
+	ByYear  Granularity = "year"
+)
+
+// UndatedKey is the key of the timeline group holding items without a
+// creation date
+const UndatedKey = "undated"
+
+// TimelineGroup is a bucket of items created in the same day, month or year
+type TimelineGroup struct {
+	// Key identifies the bucket: "2006-01-02", "2006-01", "2006" or UndatedKey
+	Key string `json:"key"`
+	// Start and End delimit the bucket, End is exclusive. Both are zero
+	// for the undated group.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	Count  int `json:"count"`
+	Photos int `json:"photos"`
+	Videos int `json:"videos"`
+
+	// Cover is the first photo of the group, or the first video if the
+	// group only contains videos
+	Cover Image `json:"cover"`
+	// Items are ordered by creation date
+	Items []Image `json:"items"`
+}
+
+// ParseGranularity parses "day", "month" or "year"
+func ParseGranularity(s string) (Granularity, error) {
+	switch g := Granularity(s); g {
+	case ByDay, ByMonth, ByYear:
+		return g, nil
+	default:
+		return "", fmt.Errorf("unknown granularity %q", s)
+	}
+}
+
+// Timeline buckets the items of resp by creation date in loc, which
+// defaults to UTC. Groups are ordered chronologically; items without a
+// creation date are collected in a final group with key UndatedKey.
+func Timeline(resp *Response, by Granularity, loc *time.Location) []TimelineGroup {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	photos := make([]Image, len(resp.Photos))
+	copy(photos, resp.Photos)
+	sort.SliceStable(photos, func(i, j int) bool {
+		return photos[i].DateCreated.Before(photos[j].DateCreated)
+	})
+
+	var groups []TimelineGroup
+	var undated *TimelineGroup
+	index := make(map[string]int)
+
+	for _, photo := range photos {
+		var group *TimelineGroup
+		if photo.DateCreated.IsZero() {
+			if undated == nil {
+				undated = &TimelineGroup{Key: UndatedKey}
+			}
+			group = undated
+		} else {
+			start, end, key := bucket(photo.DateCreated.In(loc), by)
+			i, ok := index[key]
+			if !ok {
+				i = len(groups)
+				index[key] = i
+				groups = append(groups, TimelineGroup{Key: key, Start: start, End: end})
+			}
+			group = &groups[i]
+		}
+
+		group.Count++
+		if isVideo(photo) {
+			group.Videos++
+		} else {
+			if group.Photos == 0 {
+				group.Cover = photo
+			}
+			group.Photos++
+		}
+		if group.Count == 1 {
+			group.Cover = photo
+		}
+		group.Items = append(group.Items, photo)
+	}
+
+	if undated != nil {
+		groups = append(groups, *undated)
+	}
+	return groups
+}
+
+// bucket returns the bounds and key of the bucket containing t
+func bucket(t time.Time, by Granularity) (start, end time.Time, key string) {
+	switch by {
+	case ByYear:
+		start = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(1, 0, 0), start.Format("2006")
+	case ByMonth:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 1, 0), start.Format("2006-01")
+	default:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 0, 1), start.Format("2006-01-02")
+	}
+}
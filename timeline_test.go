@@ -0,0 +1,159 @@
+package icloudalbum
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGranularity(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Granularity
+		wantErr bool
+	}{
+		{"day", ByDay, false},
+		{"month", ByMonth, false},
+		{"year", ByYear, false},
+		{"week", "", true},
+		{"Day", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseGranularity(tt.in)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseGranularity(%q) = %q, %v, want %q, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// timelineAlbum is out of order and has a video-only day, an undated
+// photo and a photo created on 1 January just after midnight in CET
+func timelineAlbum() *Response {
+	video := stringPtr("video")
+	return &Response{Photos: []Image{
+		{PhotoGUID: "P3", DateCreated: time.Date(2024, 2, 10, 9, 0, 0, 0, time.UTC)},
+		{PhotoGUID: "U1"},
+		{PhotoGUID: "V1", DateCreated: time.Date(2024, 2, 10, 8, 0, 0, 0, time.UTC), MediaAssetType: video},
+		{PhotoGUID: "P2", DateCreated: time.Date(2024, 2, 10, 10, 0, 0, 0, time.UTC)},
+		{PhotoGUID: "V2", DateCreated: time.Date(2024, 2, 12, 8, 0, 0, 0, time.UTC), MediaAssetType: video},
+		{PhotoGUID: "P1", DateCreated: time.Date(2023, 12, 31, 23, 30, 0, 0, time.UTC)},
+	}}
+}
+
+func TestTimeline(t *testing.T) {
+	type group struct {
+		key                   string
+		count, photos, videos int
+		cover                 string
+		items                 string
+	}
+
+	tests := []struct {
+		name string
+		by   Granularity
+		loc  *time.Location
+		want []group
+	}{
+		{
+			name: "day",
+			by:   ByDay,
+			want: []group{
+				{"2023-12-31", 1, 1, 0, "P1", "P1"},
+				{"2024-02-10", 3, 2, 1, "P3", "V1,P3,P2"},
+				{"2024-02-12", 1, 0, 1, "V2", "V2"},
+				{UndatedKey, 1, 1, 0, "U1", "U1"},
+			},
+		},
+		{
+			name: "month",
+			by:   ByMonth,
+			want: []group{
+				{"2023-12", 1, 1, 0, "P1", "P1"},
+				{"2024-02", 4, 2, 2, "P3", "V1,P3,P2,V2"},
+				{UndatedKey, 1, 1, 0, "U1", "U1"},
+			},
+		},
+		{
+			name: "year",
+			by:   ByYear,
+			want: []group{
+				{"2023", 1, 1, 0, "P1", "P1"},
+				{"2024", 4, 2, 2, "P3", "V1,P3,P2,V2"},
+				{UndatedKey, 1, 1, 0, "U1", "U1"},
+			},
+		},
+		{
+			name: "year in location",
+			by:   ByYear,
+			loc:  time.FixedZone("CET", 3600),
+			want: []group{
+				{"2024", 5, 3, 2, "P1", "P1,V1,P3,P2,V2"},
+				{UndatedKey, 1, 1, 0, "U1", "U1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := timelineAlbum()
+			groups := Timeline(resp, tt.by, tt.loc)
+
+			if len(groups) != len(tt.want) {
+				t.Fatalf("got %d groups, want %d", len(groups), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := groups[i]
+				var items []string
+				for _, item := range got.Items {
+					items = append(items, item.PhotoGUID)
+				}
+				if got.Key != want.key || got.Count != want.count || got.Photos != want.photos ||
+					got.Videos != want.videos || got.Cover.PhotoGUID != want.cover || strings.Join(items, ",") != want.items {
+					t.Errorf("group %d = %s count %d photos %d videos %d cover %s items %v, want %+v",
+						i, got.Key, got.Count, got.Photos, got.Videos, got.Cover.PhotoGUID, items, want)
+				}
+			}
+
+			if resp.Photos[0].PhotoGUID != "P3" || resp.Photos[5].PhotoGUID != "P1" {
+				t.Errorf("Timeline() reordered the album")
+			}
+		})
+	}
+}
+
+func TestTimelineBounds(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	created := time.Date(2024, 2, 29, 23, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		by         Granularity
+		loc        *time.Location
+		start, end time.Time
+	}{
+		{ByDay, nil, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{ByDay, cet, time.Date(2024, 3, 1, 0, 0, 0, 0, cet), time.Date(2024, 3, 2, 0, 0, 0, 0, cet)},
+		{ByMonth, nil, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{ByYear, nil, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.by), func(t *testing.T) {
+			groups := Timeline(&Response{Photos: []Image{{DateCreated: created}}}, tt.by, tt.loc)
+			if len(groups) != 1 {
+				t.Fatalf("got %d groups, want 1", len(groups))
+			}
+			if !groups[0].Start.Equal(tt.start) || !groups[0].End.Equal(tt.end) {
+				t.Errorf("bounds = %v - %v, want %v - %v", groups[0].Start, groups[0].End, tt.start, tt.end)
+			}
+		})
+	}
+
+	undated := Timeline(&Response{Photos: []Image{{}}}, ByDay, nil)
+	if len(undated) != 1 || !undated[0].Start.IsZero() || !undated[0].End.IsZero() {
+		t.Errorf("undated group = %+v, want zero bounds", undated)
+	}
+}
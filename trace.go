@@ -0,0 +1,162 @@
+package icloudalbum
+
+import (
+	"strconv"
+	"time"
+)
+
+// ClientTrace is a set of hooks called during the lifecycle of the requests
+// made by a Client, e.g. to record metrics or latency histograms.
+// Any field may be nil. Hooks may be called concurrently from multiple
+// goroutines and must not block.
+type ClientTrace struct {
+	// RequestStart is called before a request is sent to iCloud.
+	RequestStart func(RequestInfo)
+
+	// RequestDone is called after a request finished, successfully or not.
+	RequestDone func(RequestDoneInfo)
+
+	// RedirectFollowed is called when the client follows a 307/308 host
+	// redirect or an Apple-specific 330 redirect.
+	RedirectFollowed func(RedirectInfo)
+
+	// ChunkResolved is called after the URLs for a chunk of photos have
+	// been resolved.
+	ChunkResolved func(ChunkInfo)
+
+	// RetryScheduled is called when a failed request is going to be
+	// retried after a delay.
+	RetryScheduled func(RetryInfo)
+
+	// ParseWarning is called when a field of the iCloud response could
+	// not be parsed and has been replaced by its zero value.
+	ParseWarning func(ParseWarningInfo)
+}
+
+// Endpoints reported in RequestInfo
+const (
+	// EndpointDiscovery is the request locating the host serving an album
+	EndpointDiscovery = "discovery"
+	// EndpointWebstream fetches the album metadata and photo list
+	EndpointWebstream = "webstream"
+	// EndpointWebAssetURLs resolves the download URLs of photos
+	EndpointWebAssetURLs = "webasseturls"
+	// EndpointDownload fetches media from the URL of a derivative
+	EndpointDownload = "download"
+)
+
+// RequestInfo describes a request made to iCloud
+type RequestInfo struct {
+	// Endpoint is one of EndpointDiscovery, EndpointWebstream,
+	// EndpointWebAssetURLs or EndpointDownload
+	Endpoint string
+	Method   string
+	URL      string
+	// Attempt is 0 for the first try and incremented on every retry
+	Attempt int
+}
+
+// RequestDoneInfo describes a finished request
+type RequestDoneInfo struct {
+	RequestInfo
+	StatusCode int
+	Duration   time.Duration
+	Err        error
+}
+
+// RedirectInfo describes a redirect followed by the client
+type RedirectInfo struct {
+	StatusCode int
+	From       string
+	To         string
+}
+
+// ChunkInfo describes a resolved chunk of photo URLs
+type ChunkInfo struct {
+	// Start and End are the indexes of the chunk within the requested GUIDs
+	Start    int
+	End      int
+	Total    int
+	URLs     int
+	Duration time.Duration
+}
+
+// RetryInfo describes a scheduled retry
+type RetryInfo struct {
+	RequestInfo
+	StatusCode int
+	Err        error
+	Delay      time.Duration
+}
+
+// ParseWarningInfo describes a value that could not be parsed
+type ParseWarningInfo struct {
+	PhotoGUID string
+	Field     string
+	Value     string
+	Err       error
+}
+
+func (t *ClientTrace) requestStart(info RequestInfo) {
+	if t != nil && t.RequestStart != nil {
+		t.RequestStart(info)
+	}
+}
+
+func (t *ClientTrace) requestDone(info RequestDoneInfo) {
+	if t != nil && t.RequestDone != nil {
+		t.RequestDone(info)
+	}
+}
+
+func (t *ClientTrace) redirectFollowed(info RedirectInfo) {
+	if t != nil && t.RedirectFollowed != nil {
+		t.RedirectFollowed(info)
+	}
+}
+
+func (t *ClientTrace) chunkResolved(info ChunkInfo) {
+	if t != nil && t.ChunkResolved != nil {
+		t.ChunkResolved(info)
+	}
+}
+
+func (t *ClientTrace) retryScheduled(info RetryInfo) {
+	if t != nil && t.RetryScheduled != nil {
+		t.RetryScheduled(info)
+	}
+}
+
+func (t *ClientTrace) parseWarning(info ParseWarningInfo) {
+	if t != nil && t.ParseWarning != nil {
+		t.ParseWarning(info)
+	}
+}
+
+// parseInt parses a numeric field of the iCloud response, reporting a
+// parse warning for non-empty values that are not valid integers.
+func (c *Client) parseInt(photoGUID, field, value string) int64 {
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		c.trace.parseWarning(ParseWarningInfo{PhotoGUID: photoGUID, Field: field, Value: value, Err: err})
+		return 0
+	}
+	return n
+}
+
+// parseDate parses a date field of the iCloud response, reporting a parse
+// warning for non-empty values that are not valid RFC 3339 dates.
+func (c *Client) parseDate(photoGUID, field, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		c.trace.parseWarning(ParseWarningInfo{PhotoGUID: photoGUID, Field: field, Value: value, Err: err})
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,178 @@
+package icloudalbum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		want   bool
+	}{
+		{"network error", 0, errors.New("connection reset"), true},
+		{"ok", http.StatusOK, nil, false},
+		{"not found", http.StatusNotFound, nil, false},
+		{"too many requests", http.StatusTooManyRequests, nil, true},
+		{"internal server error", http.StatusInternalServerError, nil, true},
+		{"service unavailable", http.StatusServiceUnavailable, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status}
+			}
+			if got := shouldRetry(resp, tt.err); got != tt.want {
+				t.Errorf("shouldRetry(%d, %v) = %v, want %v", tt.status, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientDoRetries(t *testing.T) {
+	const backoff = 5 * time.Millisecond
+
+	tests := []struct {
+		name       string
+		maxRetries int
+		// failures is the number of 503 responses before a 200
+		failures   int
+		wantStatus int
+		wantCalls  int
+		// wantEvents are the trace hooks called, in order
+		wantEvents []string
+	}{
+		{
+			name:       "no retries configured",
+			maxRetries: 0,
+			failures:   1,
+			wantStatus: http.StatusServiceUnavailable,
+			wantCalls:  1,
+			wantEvents: []string{"start 0", "done 0 503"},
+		},
+		{
+			name:       "success after a retry",
+			maxRetries: 3,
+			failures:   1,
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+			wantEvents: []string{"start 0", "done 0 503", "retry 0 503 5ms", "start 1", "done 1 200"},
+		},
+		{
+			name:       "backoff doubles",
+			maxRetries: 3,
+			failures:   2,
+			wantStatus: http.StatusOK,
+			wantCalls:  3,
+			wantEvents: []string{
+				"start 0", "done 0 503", "retry 0 503 5ms",
+				"start 1", "done 1 503", "retry 1 503 10ms",
+				"start 2", "done 2 200",
+			},
+		},
+		{
+			name:       "retries exhausted",
+			maxRetries: 1,
+			failures:   5,
+			wantStatus: http.StatusServiceUnavailable,
+			wantCalls:  2,
+			wantEvents: []string{"start 0", "done 0 503", "retry 0 503 5ms", "start 1", "done 1 503"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != "payload" {
+					t.Errorf("request body = %q, want payload", body)
+				}
+				if atomic.AddInt32(&calls, 1) <= int32(tt.failures) {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte("ok"))
+			}))
+			defer server.Close()
+
+			var events []string
+			trace := &ClientTrace{
+				RequestStart: func(info RequestInfo) {
+					if info.Endpoint != EndpointWebstream || info.Method != http.MethodPost || info.URL != server.URL {
+						t.Errorf("RequestStart info = %+v", info)
+					}
+					events = append(events, fmt.Sprintf("start %d", info.Attempt))
+				},
+				RequestDone: func(info RequestDoneInfo) {
+					events = append(events, fmt.Sprintf("done %d %d", info.Attempt, info.StatusCode))
+				},
+				RetryScheduled: func(info RetryInfo) {
+					events = append(events, fmt.Sprintf("retry %d %d %v", info.Attempt, info.StatusCode, info.Delay))
+				},
+			}
+			c := NewClient(WithRetry(tt.maxRetries, backoff), WithTrace(trace), WithDebugOutput(nil))
+
+			req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			resp, err := c.do(req, EndpointWebstream)
+			if err != nil {
+				t.Fatalf("do() error = %v", err)
+			}
+			resp.Body.Close()
+			elapsed := time.Since(start)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if calls := atomic.LoadInt32(&calls); int(calls) != tt.wantCalls {
+				t.Errorf("server got %d requests, want %d", calls, tt.wantCalls)
+			}
+			if strings.Join(events, ", ") != strings.Join(tt.wantEvents, ", ") {
+				t.Errorf("trace events = %q, want %q", events, tt.wantEvents)
+			}
+
+			var wantDelay time.Duration
+			for attempt := 0; attempt < tt.wantCalls-1; attempt++ {
+				wantDelay += backoff << attempt
+			}
+			if elapsed < wantDelay {
+				t.Errorf("do() took %v, want at least the backoff of %v", elapsed, wantDelay)
+			}
+		})
+	}
+}
+
+func TestClientDoRetryCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	trace := &ClientTrace{RetryScheduled: func(RetryInfo) { cancel() }}
+	c := NewClient(WithRetry(3, time.Hour), WithTrace(trace), WithDebugOutput(nil))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.do(req, EndpointDownload); !errors.Is(err, context.Canceled) {
+		t.Errorf("do() error = %v, want %v", err, context.Canceled)
+	}
+}
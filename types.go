@@ -25,6 +25,29 @@ type Image struct {
 	Height             int                  `json:"height"`
 	Width              int                  `json:"width"`
 	MediaAssetType     *string             `json:"mediaAssetType,omitempty"`
+	EXIF               *EXIF               `json:"exif,omitempty"`
+}
+
+// EXIF contains metadata read from the original file of an image
+type EXIF struct {
+	CaptureTime  *time.Time `json:"captureTime,omitempty"`
+	Make         string     `json:"make,omitempty"`
+	Model        string     `json:"model,omitempty"`
+	LensMake     string     `json:"lensMake,omitempty"`
+	LensModel    string     `json:"lensModel,omitempty"`
+	ExposureTime float64    `json:"exposureTime,omitempty"`
+	FNumber      float64    `json:"fNumber,omitempty"`
+	ISO          int        `json:"iso,omitempty"`
+	FocalLength  float64    `json:"focalLength,omitempty"`
+	Orientation  int        `json:"orientation,omitempty"`
+	GPS          *Location  `json:"gps,omitempty"`
+}
+
+// Location is a GPS position in decimal degrees
+type Location struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
 }
 
 // Metadata contains album metadata
@@ -0,0 +1,222 @@
+package icloudalbum
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of change reported by a Watcher
+type EventType string
+
+const (
+	// PhotoAdded is emitted for every photo added to an album
+	PhotoAdded EventType = "photo_added"
+	// PhotoRemoved is emitted for every photo removed from an album
+	PhotoRemoved EventType = "photo_removed"
+	// CaptionChanged is emitted when the caption of a photo was edited
+	CaptionChanged EventType = "caption_changed"
+	// AlbumRenamed is emitted when the name of an album changed
+	AlbumRenamed EventType = "album_renamed"
+	// AlbumUnavailable is emitted when an album that could be fetched
+	// before starts failing, e.g. because it was unshared
+	AlbumUnavailable EventType = "album_unavailable"
+)
+
+// Event is a change to a watched album
+type Event struct {
+	Type  EventType `json:"type"`
+	Token string    `json:"token"`
+	Time  time.Time `json:"time"`
+
+	// Photo is the added, removed or edited photo
+	Photo *Image `json:"photo,omitempty"`
+
+	// Old and New are the previous and current caption for CaptionChanged
+	// and the previous and current album name for AlbumRenamed
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+
+	// Err is the error that made the album unavailable
+	Err error `json:"-"`
+}
+
+// Watcher polls albums and reports their changes as events. The first
+// successful poll of every album establishes its state and emits no
+// events.
+type Watcher struct {
+	// Client is used to fetch the albums. Defaults to a new Client.
+	Client *Client
+
+	// Tokens are the albums to watch
+	Tokens []string
+
+	// Interval is the time between polls of an album. Defaults to one
+	// minute.
+	Interval time.Duration
+
+	// MaxBackoff caps the time between polls of an album that keeps
+	// failing. The interval doubles on every failure up to this limit.
+	// Defaults to 30 minutes.
+	MaxBackoff time.Duration
+
+	// ResolveURLs resolves the derivative URLs of added photos before
+	// emitting PhotoAdded events
+	ResolveURLs bool
+}
+
+// Watch polls every album in the background until ctx is done and returns
+// the channel events are sent on. The channel is closed once all polling
+// has stopped.
+func (w *Watcher) Watch(ctx context.Context) <-chan Event {
+	events := make(chan Event, 16)
+
+	var wg sync.WaitGroup
+	for _, token := range w.Tokens {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+			w.watch(ctx, token, events)
+		}(token)
+	}
+
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	return events
+}
+
+// watch polls a single album until ctx is done
+func (w *Watcher) watch(ctx context.Context, token string, events chan<- Event) {
+	client := w.Client
+	if client == nil {
+		client = NewClient()
+	}
+	interval := w.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	maxBackoff := w.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Minute
+	}
+
+	var (
+		last        *Snapshot
+		failures    int
+		unavailable bool
+	)
+	for {
+		ctag := ""
+		if last != nil && !unavailable {
+			ctag = last.Metadata.StreamCtag
+		}
+
+		resp, err := client.GetAlbumIfChangedContext(ctx, token, ctag)
+		now := time.Now()
+		switch {
+		case errors.Is(err, ErrNotModified):
+			failures = 0
+		case err != nil:
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			if !unavailable && last != nil {
+				if !send(ctx, events, Event{Type: AlbumUnavailable, Token: token, Time: now, Err: err}) {
+					return
+				}
+			}
+			unavailable = true
+		default:
+			failures = 0
+			unavailable = false
+
+			snapshot := NewSnapshot(resp, now)
+			if last != nil {
+				for _, event := range w.events(ctx, client, token, last, snapshot) {
+					if !send(ctx, events, event) {
+						return
+					}
+				}
+			}
+			last = snapshot
+		}
+
+		delay := interval
+		for i := 0; i < failures && delay < maxBackoff; i++ {
+			delay *= 2
+		}
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// events returns the changes from previous to current as events,
+// resolving the URLs of added photos if requested. Photos whose URLs cannot
+// be resolved are reported without.
+func (w *Watcher) events(ctx context.Context, client *Client, token string, previous, current *Snapshot) []Event {
+	diff := Diff(previous, current)
+
+	var events []Event
+	if diff.OldName != diff.NewName {
+		events = append(events, Event{Type: AlbumRenamed, Token: token, Time: diff.To, Old: diff.OldName, New: diff.NewName})
+	}
+
+	if w.ResolveURLs && len(diff.Added) > 0 {
+		guids := make([]string, 0, len(diff.Added))
+		for _, photo := range diff.Added {
+			guids = append(guids, photo.PhotoGUID)
+		}
+		if urls, err := client.ResolveURLsContext(ctx, token, guids); err == nil {
+			ApplyURLs(diff.Added, urls)
+		}
+	}
+	for i := range diff.Added {
+		events = append(events, Event{Type: PhotoAdded, Token: token, Time: diff.To, Photo: &diff.Added[i]})
+	}
+	for i := range diff.Removed {
+		events = append(events, Event{Type: PhotoRemoved, Token: token, Time: diff.To, Photo: &diff.Removed[i]})
+	}
+
+	if len(diff.CaptionChanges) > 0 {
+		photos := make(map[string]*Image, len(current.Photos))
+		for i := range current.Photos {
+			photos[current.Photos[i].PhotoGUID] = &current.Photos[i]
+		}
+		for _, change := range diff.CaptionChanges {
+			events = append(events, Event{
+				Type:  CaptionChanged,
+				Token: token,
+				Time:  diff.To,
+				Photo: photos[change.PhotoGUID],
+				Old:   change.Old,
+				New:   change.New,
+			})
+		}
+	}
+
+	return events
+}
+
+// send delivers event unless ctx is done first
+func send(ctx context.Context, events chan<- Event, event Event) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
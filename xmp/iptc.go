@@ -0,0 +1,115 @@
+package xmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// resourceIPTC is the ID of the image resource holding IPTC datasets
+const resourceIPTC = 0x0404
+
+// IPTC IIM datasets written to the APP13 segment
+const (
+	iptcCharacterSet = 90  // record 1
+	iptcCaption      = 120 // record 2
+	iptcByline       = 80  // record 2
+	iptcDateCreated  = 55  // record 2
+	iptcTimeCreated  = 60  // record 2
+	iptcRecordVer    = 0   // record 2
+)
+
+// photoshopIPTC returns the payload of an APP13 segment holding m as IPTC
+// datasets in a Photoshop image resource block. resources are other image
+// resource blocks of the file, written before the IPTC block.
+func photoshopIPTC(m Metadata, resources []byte) []byte {
+	var iptc bytes.Buffer
+
+	// Declare UTF-8 so captions are not read as Latin-1
+	writeDataset(&iptc, 1, iptcCharacterSet, []byte("\x1b%G"))
+	writeDataset(&iptc, 2, iptcRecordVer, []byte{0x00, 0x04})
+	if m.Caption != "" {
+		writeDataset(&iptc, 2, iptcCaption, truncate(m.Caption, 2000))
+	}
+	if m.Creator != "" {
+		writeDataset(&iptc, 2, iptcByline, truncate(m.Creator, 32))
+	}
+	if !m.DateCreated.IsZero() {
+		writeDataset(&iptc, 2, iptcDateCreated, []byte(m.DateCreated.Format("20060102")))
+		writeDataset(&iptc, 2, iptcTimeCreated, []byte(m.DateCreated.Format("150405-0700")))
+	}
+
+	var b bytes.Buffer
+	b.Write(photoshopHeader)
+	b.Write(resources)
+	b.WriteString("8BIM")
+	binary.Write(&b, binary.BigEndian, uint16(resourceIPTC))
+	// Empty Pascal string name, padded to an even length
+	b.Write([]byte{0, 0})
+	binary.Write(&b, binary.BigEndian, uint32(iptc.Len()))
+	b.Write(iptc.Bytes())
+	if iptc.Len()%2 == 1 {
+		b.WriteByte(0)
+	}
+
+	return b.Bytes()
+}
+
+// otherResources returns the image resource blocks of the APP13 payload data
+// except the IPTC one, unchanged and in their original order.
+func otherResources(data []byte) ([]byte, error) {
+	data = data[len(photoshopHeader):]
+
+	var kept []byte
+	for len(data) > 0 {
+		// Signature ("8BIM"), ID, Pascal string name padded to an even
+		// length, data size and data padded to an even length
+		if len(data) < 7 {
+			return nil, errInvalidResource
+		}
+		id := binary.BigEndian.Uint16(data[4:6])
+		n := 6 + (1+int(data[6])+1)&^1
+		if len(data) < n+4 {
+			return nil, errInvalidResource
+		}
+		size := int(binary.BigEndian.Uint32(data[n : n+4]))
+		end := n + 4 + size
+		if len(data) < end {
+			return nil, errInvalidResource
+		}
+
+		if id != resourceIPTC {
+			kept = append(kept, data[:end]...)
+			// Some writers leave out the padding of the last block
+			if size%2 == 1 {
+				kept = append(kept, 0)
+			}
+		}
+		data = data[end:]
+		if size%2 == 1 && len(data) > 0 {
+			data = data[1:]
+		}
+	}
+	return kept, nil
+}
+
+var errInvalidResource = errors.New("xmp: invalid Photoshop image resource block")
+
+func writeDataset(b *bytes.Buffer, record, dataset byte, value []byte) {
+	b.Write([]byte{0x1C, record, dataset})
+	binary.Write(b, binary.BigEndian, uint16(len(value)))
+	b.Write(value)
+}
+
+// truncate limits s to the maximum length of an IPTC dataset without
+// splitting a UTF-8 sequence.
+func truncate(s string, max int) []byte {
+	if len(s) <= max {
+		return []byte(s)
+	}
+	end := max
+	for end > 0 && s[end]&0xC0 == 0x80 {
+		end--
+	}
+	return []byte(s[:end])
+}
@@ -0,0 +1,190 @@
+package xmp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	xmpHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	exifHeader      = []byte("Exif\x00\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+)
+
+// ErrNotJPEG is returned when embedding into a file that is not a JPEG
+var ErrNotJPEG = errors.New("xmp: not a JPEG file")
+
+const (
+	markerTEM  = 0x01
+	markerRST0 = 0xD0
+	markerRST7 = 0xD7
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerAPP0 = 0xE0
+	markerAPP1 = 0xE1
+	markerAP13 = 0xED
+)
+
+type segment struct {
+	marker byte
+	data   []byte
+}
+
+// EmbedJPEG copies the JPEG from r to w with m embedded as an XMP packet
+// (APP1) and as IPTC datasets (APP13). Existing XMP and IPTC are replaced;
+// EXIF, other Photoshop image resources and all other segments are kept.
+func EmbedJPEG(r io.Reader, w io.Writer, m Metadata) error {
+	br := bufio.NewReader(r)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return ErrNotJPEG
+	}
+
+	// Read all segments up to the start of scan
+	var segments []segment
+	var resources []byte
+	var sos byte
+	for {
+		marker, err := readMarker(br)
+		if err != nil {
+			return err
+		}
+		if marker == markerSOS {
+			sos = marker
+			break
+		}
+		if !hasLength(marker) {
+			segments = append(segments, segment{marker: marker})
+			continue
+		}
+
+		var length uint16
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil {
+			return fmt.Errorf("xmp: reading segment length: %w", err)
+		}
+		if length < 2 {
+			return fmt.Errorf("xmp: invalid segment length %d", length)
+		}
+		data := make([]byte, length-2)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("xmp: reading segment: %w", err)
+		}
+
+		if marker == markerAPP1 && bytes.HasPrefix(data, xmpHeader) {
+			continue
+		}
+		if marker == markerAP13 && bytes.HasPrefix(data, photoshopHeader) {
+			// Keep the other resources, e.g. from Photoshop, and write
+			// them into our segment
+			other, err := otherResources(data)
+			if err != nil {
+				return err
+			}
+			resources = append(resources, other...)
+			continue
+		}
+		segments = append(segments, segment{marker: marker, data: data})
+	}
+
+	packet := append(append([]byte{}, xmpHeader...), Packet(m)...)
+	if len(packet) > 0xFFFF-2 {
+		return fmt.Errorf("xmp: packet too large to embed (%d bytes)", len(packet))
+	}
+	iptc := photoshopIPTC(m, resources)
+	if len(iptc) > 0xFFFF-2 {
+		return fmt.Errorf("xmp: Photoshop resources too large to embed (%d bytes)", len(iptc))
+	}
+	ours := []segment{
+		{marker: markerAPP1, data: packet},
+		{marker: markerAP13, data: iptc},
+	}
+
+	// Insert after JFIF and EXIF, which readers expect first
+	insertAt := 0
+	for insertAt < len(segments) {
+		s := segments[insertAt]
+		if s.marker != markerAPP0 && !(s.marker == markerAPP1 && bytes.HasPrefix(s.data, exifHeader)) {
+			break
+		}
+		insertAt++
+	}
+	segments = append(segments[:insertAt], append(ours, segments[insertAt:]...)...)
+
+	bw := bufio.NewWriter(w)
+	bw.Write(soi[:])
+	for _, s := range segments {
+		bw.Write([]byte{0xFF, s.marker})
+		if !hasLength(s.marker) {
+			continue
+		}
+		binary.Write(bw, binary.BigEndian, uint16(len(s.data)+2))
+		bw.Write(s.data)
+	}
+	bw.Write([]byte{0xFF, sos})
+	if _, err := io.Copy(bw, br); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// EmbedFile embeds m into the JPEG file at path, replacing it atomically
+func EmbedFile(path string, m Metadata) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".xmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := EmbedJPEG(in, tmp, m); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	in.Close()
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// hasLength reports whether a segment with marker has a length and data.
+// TEM, RSTn, SOI and EOI are markers on their own.
+func hasLength(marker byte) bool {
+	switch {
+	case marker == markerTEM, marker == markerSOI, marker == markerEOI:
+		return false
+	case marker >= markerRST0 && marker <= markerRST7:
+		return false
+	}
+	return true
+}
+
+func readMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("xmp: reading marker: %w", err)
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("xmp: invalid JPEG marker")
+	}
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, fmt.Errorf("xmp: reading marker: %w", err)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,248 @@
+package xmp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// jpegSegment returns a segment with marker and data as found in a file
+func jpegSegment(marker byte, data ...[]byte) []byte {
+	payload := bytes.Join(data, nil)
+	b := []byte{0xFF, marker}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+// resourceBlock returns a Photoshop image resource block. pad adds the
+// padding byte of data with an odd length.
+func resourceBlock(id uint16, name string, data []byte, pad bool) []byte {
+	b := []byte("8BIM")
+	b = binary.BigEndian.AppendUint16(b, id)
+	b = append(b, byte(len(name)))
+	b = append(b, name...)
+	if len(name)%2 == 0 {
+		b = append(b, 0)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	if pad && len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// readSegments returns the segments of a JPEG up to the start of scan and
+// the rest of the file
+func readSegments(t *testing.T, data []byte) ([]segment, []byte) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		t.Fatal("output does not start with SOI")
+	}
+	br := bufio.NewReader(bytes.NewReader(data[2:]))
+	var segments []segment
+	for {
+		marker, err := readMarker(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if marker == markerSOS {
+			var rest bytes.Buffer
+			rest.ReadFrom(br)
+			return segments, rest.Bytes()
+		}
+		if !hasLength(marker) {
+			segments = append(segments, segment{marker: marker})
+			continue
+		}
+		var length uint16
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil {
+			t.Fatal(err)
+		}
+		s := segment{marker: marker, data: make([]byte, length-2)}
+		if _, err := io.ReadFull(br, s.data); err != nil {
+			t.Fatal(err)
+		}
+		segments = append(segments, s)
+	}
+}
+
+// readIPTC returns the datasets of the IPTC resource in an APP13 payload
+// keyed by "record:dataset"
+func readIPTC(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	data = data[len(photoshopHeader):]
+	for len(data) > 0 {
+		id := binary.BigEndian.Uint16(data[4:6])
+		n := 6 + (1+int(data[6])+1)&^1
+		size := int(binary.BigEndian.Uint32(data[n : n+4]))
+		block := data[n+4 : n+4+size]
+		data = data[n+4+(size+1)&^1:]
+		if id != resourceIPTC {
+			continue
+		}
+
+		datasets := make(map[string]string)
+		for len(block) >= 5 {
+			if block[0] != 0x1C {
+				t.Fatalf("invalid IPTC dataset tag %#x", block[0])
+			}
+			length := int(binary.BigEndian.Uint16(block[3:5]))
+			key := fmt.Sprintf("%d:%d", block[1], block[2])
+			datasets[key] = string(block[5 : 5+length])
+			block = block[5+length:]
+		}
+		return datasets
+	}
+	t.Fatal("APP13 has no IPTC resource")
+	return nil
+}
+
+func TestEmbedJPEG(t *testing.T) {
+	m := Metadata{
+		Caption:     "Beach & sun",
+		Creator:     "Ada Lovelace",
+		DateCreated: time.Date(2024, 6, 2, 12, 30, 0, 0, time.UTC),
+		Identifier:  "P1",
+		Source:      "Summer",
+	}
+
+	jfif := jpegSegment(markerAPP0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+	exif := jpegSegment(markerAPP1, exifHeader, []byte("MM\x00\x2a\x00\x00\x00\x08"))
+	oldXMP := jpegSegment(markerAPP1, xmpHeader, []byte("<x:xmpmeta>old</x:xmpmeta>"))
+	dqt := jpegSegment(0xDB, make([]byte, 65))
+	scan := []byte{0x00, 0x0C, 0x01, 0x01, 0x00, 0x00, 0x3F, 0x00, 0x12, 0xFF, 0x00, 0x34, 0xFF, 0xD9}
+	resolution := resourceBlock(0x03ED, "", []byte("0123456789abcdef"), true)
+	caption := resourceBlock(0x03F0, "cap", []byte("odd"), true)
+	oldIPTC := resourceBlock(resourceIPTC, "", []byte("\x1c\x02\x78\x00\x03old"), true)
+
+	jpeg := func(segments ...[]byte) []byte {
+		b := []byte{0xFF, 0xD8}
+		for _, s := range segments {
+			b = append(b, s...)
+		}
+		b = append(b, 0xFF, markerSOS)
+		return append(b, scan...)
+	}
+
+	tests := []struct {
+		name string
+		in   []byte
+		// wantMarkers are the markers of the output up to the start of scan
+		wantMarkers []byte
+		// wantResources are the image resources kept before our IPTC block
+		wantResources []byte
+		wantErr       error
+	}{
+		{
+			name:        "JFIF only",
+			in:          jpeg(jfif, dqt),
+			wantMarkers: []byte{markerAPP0, markerAPP1, markerAP13, 0xDB},
+		},
+		{
+			name: "existing EXIF, XMP and Photoshop resources",
+			in: jpeg(jfif, exif, oldXMP,
+				jpegSegment(markerAP13, photoshopHeader, resolution, oldIPTC, caption), dqt),
+			wantMarkers:   []byte{markerAPP0, markerAPP1, markerAPP1, markerAP13, 0xDB},
+			wantResources: append(append([]byte{}, resolution...), caption...),
+		},
+		{
+			name:          "last resource without padding",
+			in:            jpeg(exif, jpegSegment(markerAP13, photoshopHeader, oldIPTC, resourceBlock(0x03F0, "cap", []byte("odd"), false))),
+			wantMarkers:   []byte{markerAPP1, markerAPP1, markerAP13},
+			wantResources: caption,
+		},
+		{
+			name:        "markers without length",
+			in:          jpeg(jfif, []byte{0xFF, markerTEM}, []byte{0xFF, markerRST0 + 3}, dqt),
+			wantMarkers: []byte{markerAPP0, markerAPP1, markerAP13, markerTEM, markerRST0 + 3, 0xDB},
+		},
+		{
+			name:    "not a JPEG",
+			in:      []byte("GIF89a"),
+			wantErr: ErrNotJPEG,
+		},
+		{
+			name:    "invalid resource block",
+			in:      jpeg(jpegSegment(markerAP13, photoshopHeader, resolution[:10])),
+			wantErr: errInvalidResource,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := EmbedJPEG(bytes.NewReader(tt.in), &out, m)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("EmbedJPEG() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EmbedJPEG() error = %v", err)
+			}
+
+			segments, rest := readSegments(t, out.Bytes())
+			if !bytes.Equal(rest, scan) {
+				t.Errorf("scan data changed")
+			}
+			var markers []byte
+			var xmps, app13s [][]byte
+			for _, s := range segments {
+				markers = append(markers, s.marker)
+				switch {
+				case s.marker == markerAPP1 && bytes.HasPrefix(s.data, xmpHeader):
+					xmps = append(xmps, s.data)
+				case s.marker == markerAPP1 && !bytes.Equal(s.data, exif[4:]):
+					t.Errorf("APP1 segment changed: %q", s.data)
+				case s.marker == markerAP13:
+					app13s = append(app13s, s.data)
+				}
+			}
+			if !bytes.Equal(markers, tt.wantMarkers) {
+				t.Errorf("markers = % x, want % x", markers, tt.wantMarkers)
+			}
+
+			if len(xmps) != 1 {
+				t.Fatalf("got %d XMP segments, want 1", len(xmps))
+			}
+			if packet := xmps[0][len(xmpHeader):]; !bytes.Equal(packet, Packet(m)) {
+				t.Errorf("XMP packet = %s", packet)
+			}
+
+			if len(app13s) != 1 || !bytes.HasPrefix(app13s[0], photoshopHeader) {
+				t.Fatalf("got %d Photoshop APP13 segments, want 1", len(app13s))
+			}
+			resources, err := otherResources(app13s[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(resources, tt.wantResources) {
+				t.Errorf("kept resources = %q, want %q", resources, tt.wantResources)
+			}
+			want := map[string]string{
+				"1:90":  "\x1b%G",
+				"2:0":   "\x00\x04",
+				"2:120": m.Caption,
+				"2:80":  m.Creator,
+				"2:55":  "20240602",
+				"2:60":  "123000+0000",
+			}
+			got := readIPTC(t, app13s[0])
+			for key, value := range want {
+				if got[key] != value {
+					t.Errorf("IPTC %s = %q, want %q", key, got[key], value)
+				}
+			}
+			if len(got) != len(want) {
+				t.Errorf("IPTC datasets = %q, want %q", got, want)
+			}
+		})
+	}
+}
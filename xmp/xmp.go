@@ -0,0 +1,93 @@
+// Package xmp writes shared album metadata in a form photo managers such as
+// digiKam, Lightroom or darktable understand: XMP sidecar files, and XMP and
+// IPTC segments embedded into JPEG files.
+package xmp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"time"
+)
+
+// Metadata is the information written for a photo
+type Metadata struct {
+	// Caption is written as dc:description, dc:title and IPTC Caption/Abstract
+	Caption string
+	// Creator is written as dc:creator and IPTC By-line
+	Creator string
+	// DateCreated is written as photoshop:DateCreated, xmp:CreateDate and
+	// IPTC Date/Time Created
+	DateCreated time.Time
+	// Identifier is written as dc:identifier, e.g. the photo GUID
+	Identifier string
+	// Source is written as dc:source, e.g. the album name
+	Source string
+}
+
+// Packet returns m as a complete XMP packet
+func Packet(m Metadata) []byte {
+	var b bytes.Buffer
+
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	b.WriteString("    xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"\n")
+	b.WriteString("    xmlns:photoshop=\"http://ns.adobe.com/photoshop/1.0/\"")
+
+	if !m.DateCreated.IsZero() {
+		date := m.DateCreated.Format(time.RFC3339)
+		b.WriteString("\n    photoshop:DateCreated=\"" + escape(date) + "\"")
+		b.WriteString("\n    xmp:CreateDate=\"" + escape(date) + "\"")
+	}
+	if m.Identifier != "" {
+		b.WriteString("\n    dc:identifier=\"" + escape(m.Identifier) + "\"")
+	}
+	if m.Source != "" {
+		b.WriteString("\n    dc:source=\"" + escape(m.Source) + "\"")
+	}
+	b.WriteString(">\n")
+
+	if m.Caption != "" {
+		for _, property := range []string{"dc:description", "dc:title"} {
+			b.WriteString("   <" + property + ">\n")
+			b.WriteString("    <rdf:Alt>\n")
+			b.WriteString("     <rdf:li xml:lang=\"x-default\">" + escape(m.Caption) + "</rdf:li>\n")
+			b.WriteString("    </rdf:Alt>\n")
+			b.WriteString("   </" + property + ">\n")
+		}
+	}
+	if m.Creator != "" {
+		b.WriteString("   <dc:creator>\n")
+		b.WriteString("    <rdf:Seq>\n")
+		b.WriteString("     <rdf:li>" + escape(m.Creator) + "</rdf:li>\n")
+		b.WriteString("    </rdf:Seq>\n")
+		b.WriteString("   </dc:creator>\n")
+	}
+
+	b.WriteString("  </rdf:Description>\n")
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>")
+
+	return b.Bytes()
+}
+
+// SidecarPath returns the sidecar path for the file at path. The full file
+// name is kept (photo.jpg.xmp) as digiKam and darktable expect.
+func SidecarPath(path string) string {
+	return path + ".xmp"
+}
+
+// WriteSidecar writes m as an XMP sidecar next to the file at path
+func WriteSidecar(path string, m Metadata) error {
+	return os.WriteFile(SidecarPath(path), Packet(m), 0o644)
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}